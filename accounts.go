@@ -0,0 +1,263 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// linkedAccount ties a discord member to the plex account they use on the guild's server
+type linkedAccount struct {
+	GuildID   string `toml:"guildID"`
+	DiscordID string `toml:"discordID"`
+	// PlexUser is the plex username or the email the member was invited with
+	PlexUser string    `toml:"plexUser"`
+	LinkedBy string    `toml:"linkedBy"`
+	LinkedAt time.Time `toml:"linkedAt"`
+}
+
+// matches reports whether the account belongs to a plex user on our server
+func (a linkedAccount) matches(user sharedServer) bool {
+	return strings.EqualFold(a.PlexUser, user.Username) || strings.EqualFold(a.PlexUser, user.Email)
+}
+
+// linkAccount saves a discord member's plex account
+//
+// a member has one plex account per guild and a plex account belongs to one member
+func linkAccount(services *clients, account linkedAccount) error {
+	return services.data.update(func(data *dobbyData) {
+		accounts := data.Accounts[:0]
+
+		for _, existing := range data.Accounts {
+			if existing.GuildID == account.GuildID &&
+				(existing.DiscordID == account.DiscordID || strings.EqualFold(existing.PlexUser, account.PlexUser)) {
+				continue
+			}
+
+			accounts = append(accounts, existing)
+		}
+
+		data.Accounts = append(accounts, account)
+	})
+}
+
+// unlinkAccount forgets a discord member's plex account
+func unlinkAccount(services *clients, guildID, discordID string) error {
+	return services.data.update(func(data *dobbyData) {
+		accounts := data.Accounts[:0]
+
+		for _, existing := range data.Accounts {
+			if existing.GuildID == guildID && existing.DiscordID == discordID {
+				continue
+			}
+
+			accounts = append(accounts, existing)
+		}
+
+		data.Accounts = accounts
+	})
+}
+
+// findAccount returns the first linked account of a guild matching fn
+func findAccount(services *clients, guildID string, fn func(account linkedAccount) bool) (linkedAccount, bool) {
+	var found linkedAccount
+	ok := false
+
+	services.data.view(func(data dobbyData) {
+		for _, account := range data.Accounts {
+			if account.GuildID == guildID && fn(account) {
+				found = account
+				ok = true
+				return
+			}
+		}
+	})
+
+	return found, ok
+}
+
+// findAccountByDiscord returns the plex account linked to a discord member
+func findAccountByDiscord(services *clients, guildID, discordID string) (linkedAccount, bool) {
+	return findAccount(services, guildID, func(account linkedAccount) bool {
+		return account.DiscordID == discordID
+	})
+}
+
+// findAccountByPlex returns the discord member linked to a plex user
+func findAccountByPlex(services *clients, guildID string, user sharedServer) (linkedAccount, bool) {
+	return findAccount(services, guildID, func(account linkedAccount) bool {
+		return account.matches(user)
+	})
+}
+
+// resolvePlexUser turns a command argument into a plex username or email.
+// discord mentions are looked up in the linked accounts
+//
+// the discord id is empty when arg is not a mention
+func resolvePlexUser(services *clients, guildID, arg string) (string, string, error) {
+	discordID, ok := parseMention(arg)
+
+	if !ok {
+		return arg, "", nil
+	}
+
+	account, ok := findAccountByDiscord(services, guildID, discordID)
+
+	if !ok {
+		return "", discordID, fmt.Errorf("<@%s> has no linked plex account -- use `link-account @member <username|email>`", discordID)
+	}
+
+	return account.PlexUser, discordID, nil
+}
+
+// linkMemberAccount links a discord member to a plex account
+//
+// command: link-account <username|email> | link-account @member <username|email> | link-account [@member] --remove
+func linkMemberAccount(commandList d, services *clients) func(m *discordgo.Message, args ...string) bool {
+	return func(m *discordgo.Message, args ...string) bool {
+		channelID := m.ChannelID
+		guildID := commandList.getGuildID(channelID)
+
+		args, options, err := parseOptions(args, optionList{"remove": false})
+
+		if err != nil {
+			commandList.showError(channelID, err.Error())
+			return false
+		}
+
+		discordID := m.Author.ID
+
+		if len(args) > 0 {
+			if userID, ok := parseMention(args[0]); ok {
+				discordID = userID
+				args = args[1:]
+			}
+		}
+
+		if discordID != m.Author.ID && !isAdmin(commandList, services, m.Author.ID, channelID) {
+			commandList.showError(channelID, "only admins can link someone else's account")
+			return false
+		}
+
+		if _, remove := options["remove"]; remove {
+			if _, ok := findAccountByDiscord(services, guildID, discordID); !ok {
+				commandList.showError(channelID, fmt.Sprintf("<@%s> has no linked plex account", discordID))
+				return false
+			}
+
+			if err := unlinkAccount(services, guildID, discordID); err != nil {
+				fmt.Printf("linkMemberAccount() - could not save accounts: %v\n", err)
+				commandList.showError(channelID, "`internal error - could not save linked accounts`")
+				return false
+			}
+
+			audit(guildID, m.Author.ID, "unlink-account", "member="+discordID)
+
+			commandList.discord.ChannelMessageSend(channelID, fmt.Sprintf("<@%s> no longer has a linked plex account", discordID))
+
+			return true
+		}
+
+		if len(args) < 1 {
+			commandList.showError(channelID, "a plex username or an email is required")
+			return false
+		}
+
+		plexUser := args[0]
+
+		if owner, ok := findAccount(services, guildID, func(account linkedAccount) bool {
+			return strings.EqualFold(account.PlexUser, plexUser)
+		}); ok && owner.DiscordID != discordID && !isAdmin(commandList, services, m.Author.ID, channelID) {
+			commandList.showError(channelID, fmt.Sprintf("`%s` is already linked to someone else -- ask an admin", plexUser))
+			return false
+		}
+
+		err = linkAccount(services, linkedAccount{
+			GuildID:   guildID,
+			DiscordID: discordID,
+			PlexUser:  plexUser,
+			LinkedBy:  m.Author.ID,
+			LinkedAt:  time.Now(),
+		})
+
+		if err != nil {
+			fmt.Printf("linkMemberAccount() - could not save accounts: %v\n", err)
+			commandList.showError(channelID, "`internal error - could not save linked accounts`")
+			return false
+		}
+
+		audit(guildID, m.Author.ID, "link-account", fmt.Sprintf("member=%s user=%s", discordID, plexUser))
+
+		commandList.discord.ChannelMessageSend(channelID, fmt.Sprintf("<@%s> is now linked to `%s` on Plex", discordID, plexUser))
+
+		return true
+	}
+}
+
+// whois shows which plex account a discord member uses, or which member a plex user is
+//
+// command: whois <@member|username|email>
+func whois(commandList d, services *clients) func(m *discordgo.Message, args ...string) bool {
+	return func(m *discordgo.Message, args ...string) bool {
+		channelID := m.ChannelID
+		guildID := commandList.getGuildID(channelID)
+
+		if len(args) < 1 {
+			commandList.showError(channelID, "a @mention, a plex username or an email is required")
+			return false
+		}
+
+		var account linkedAccount
+		var ok bool
+
+		link := services.getPlexLink(guildID)
+
+		var user sharedServer
+		var shared []sharedServer
+
+		if link.authorized() {
+			if machineID, err := link.client().GetMachineID(); err != nil {
+				fmt.Printf("whois() - could not fetch machine id: %v\n", err)
+			} else if shared, err = getSharedServers(link.client(), machineID); err != nil {
+				fmt.Printf("whois() - could not fetch shared users: %v\n", err)
+			}
+		}
+
+		if discordID, isMention := parseMention(args[0]); isMention {
+			if account, ok = findAccountByDiscord(services, guildID, discordID); !ok {
+				commandList.discord.ChannelMessageSend(channelID, fmt.Sprintf("<@%s> has no linked plex account", discordID))
+				return true
+			}
+		} else {
+			user, ok = findSharedServer(shared, args[0])
+
+			if !ok {
+				user = sharedServer{Username: args[0]}
+			}
+
+			if account, ok = findAccountByPlex(services, guildID, user); !ok {
+				commandList.discord.ChannelMessageSend(channelID, fmt.Sprintf("`%s` is not linked to a discord member", args[0]))
+				return true
+			}
+		}
+
+		message := fmt.Sprintf("<@%s> is `%s` on Plex (linked by <@%s> %s)",
+			account.DiscordID, account.PlexUser, account.LinkedBy, formatAge(account.LinkedAt))
+
+		if link.authorized() && shared != nil {
+			if user, ok = findSharedServer(shared, account.PlexUser); !ok {
+				message += "\naccess: none"
+			} else if user.isPending() {
+				message += "\naccess: invite pending"
+			} else {
+				message += "\naccess: yes"
+			}
+		}
+
+		commandList.discord.ChannelMessageSend(channelID, message)
+
+		return true
+	}
+}
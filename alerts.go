@@ -0,0 +1,289 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	alertTranscodes  = "transcodes"
+	alertBandwidth   = "bandwidth"
+	alert4KTranscode = "4k-transcode"
+
+	// defaultAlertCooldown is how long a rule stays quiet after it alerted
+	defaultAlertCooldown = "30m"
+)
+
+// sessionAlert is a rule checked against what is playing, admins are alerted when it matches
+type sessionAlert struct {
+	Kind string `toml:"kind"`
+	// Threshold is the number of transcodes or the bandwidth in Mbps the rule allows
+	Threshold int `toml:"threshold"`
+	// User limits 4k-transcode rules to one plex user, empty is everyone
+	User string `toml:"user"`
+}
+
+// describe is the rule for people to read
+func (a sessionAlert) describe() string {
+	switch a.Kind {
+	case alertTranscodes:
+		return fmt.Sprintf("more than %d transcodes", a.Threshold)
+	case alertBandwidth:
+		return fmt.Sprintf("more than %d Mbps in total", a.Threshold)
+	}
+
+	if a.User == "" {
+		return "anyone transcoding 4k"
+	}
+
+	return a.User + " transcoding 4k"
+}
+
+// isTranscoding4K is true when the video of a 4k file is converted for the player
+func (s playSession) isTranscoding4K() bool {
+	return s.resolution() == "4k" && s.TranscodeSession != nil && s.TranscodeSession.VideoDecision == "transcode"
+}
+
+// matchAlert returns the sessions that make a rule go off, grouped by what the cooldown applies to
+func matchAlert(alert sessionAlert, sessions []playSession) map[string][]playSession {
+	matches := map[string][]playSession{}
+
+	switch alert.Kind {
+	case alertTranscodes:
+		transcoding := []playSession{}
+
+		for _, s := range sessions {
+			if s.isTranscoding() {
+				transcoding = append(transcoding, s)
+			}
+		}
+
+		if len(transcoding) > alert.Threshold {
+			matches[""] = transcoding
+		}
+
+	case alertBandwidth:
+		bandwidth := 0
+
+		for _, s := range sessions {
+			bandwidth += s.Session.Bandwidth
+		}
+
+		if bandwidth > alert.Threshold*1000 {
+			matches[""] = sessions
+		}
+
+	case alert4KTranscode:
+		// a user transcoding 4k on one device shouldn't keep another user's alert quiet
+		for _, s := range sessions {
+			if s.isTranscoding4K() && (alert.User == "" || strings.EqualFold(alert.User, s.User.Title)) {
+				matches[strings.ToLower(s.User.Title)] = append(matches[strings.ToLower(s.User.Title)], s)
+			}
+		}
+	}
+
+	return matches
+}
+
+// checkSessionAlerts alerts admins about the rules that match what is playing.
+//
+// lastAlerts holds when each rule last alerted so it stays quiet for the cooldown
+func checkSessionAlerts(commandList d, services *clients, guildID string, settings guildSettings, sessions []playSession, lastAlerts map[string]time.Time) {
+	cooldown, err := parseDuration(settings.AlertCooldown)
+
+	if settings.AlertCooldown == "" || err != nil {
+		cooldown, _ = parseDuration(defaultAlertCooldown)
+	}
+
+	bandwidth := 0
+
+	for _, s := range sessions {
+		bandwidth += s.Session.Bandwidth
+	}
+
+	for _, alert := range settings.SessionAlerts {
+		for target, matched := range matchAlert(alert, sessions) {
+			key := alert.describe() + "|" + target
+
+			if time.Since(lastAlerts[key]) < cooldown {
+				continue
+			}
+
+			lastAlerts[key] = time.Now()
+
+			lines := make([]string, len(matched))
+
+			for i, s := range matched {
+				lines[i] = describeSession(services, guildID, s)
+			}
+
+			alertAdmins(commandList, services, guildID, fmt.Sprintf("Alert: %s\n%d streams · %s in total\n%s",
+				alert.describe(), len(sessions), formatBandwidth(bandwidth), strings.Join(lines, "\n")))
+		}
+	}
+}
+
+// alerts shows or changes the rules admins are alerted about
+//
+// command: alerts | alerts add transcodes <n> | alerts add bandwidth <mbps> | alerts add 4k-transcode [user] | alerts remove <number>
+func alerts(commandList d, services *clients) func(m *discordgo.Message, args ...string) bool {
+	return func(m *discordgo.Message, args ...string) bool {
+		channelID := m.ChannelID
+		guildID := commandList.getGuildID(channelID)
+
+		if guildID == "" {
+			commandList.showError(channelID, "alerts can only be managed from a server channel")
+			return false
+		}
+
+		usage := "usage: `alerts`, `alerts add transcodes <n>`, `alerts add bandwidth <mbps>`, `alerts add 4k-transcode [user]` or `alerts remove <number>`"
+
+		if len(args) < 1 {
+			settings := services.getGuildSettings(guildID)
+
+			if len(settings.SessionAlerts) == 0 {
+				commandList.discord.ChannelMessageSend(channelID, "no alert rules -- "+usage)
+				return true
+			}
+
+			lines := make([]string, len(settings.SessionAlerts))
+
+			for i, alert := range settings.SessionAlerts {
+				lines[i] = fmt.Sprintf("%d. %s", i+1, alert.describe())
+			}
+
+			cooldown := settings.AlertCooldown
+
+			if cooldown == "" {
+				cooldown = defaultAlertCooldown
+			}
+
+			message := fmt.Sprintf("Admins are alerted about:\n%s\neach rule stays quiet for %s after it alerted", strings.Join(lines, "\n"), cooldown)
+
+			commandList.discord.ChannelMessageSend(channelID, message)
+
+			return true
+		}
+
+		switch {
+		case args[0] == "add" && len(args) >= 2:
+			alert := sessionAlert{Kind: strings.ToLower(args[1])}
+
+			switch alert.Kind {
+			case alertTranscodes, alertBandwidth:
+				if len(args) < 3 {
+					commandList.showError(channelID, fmt.Sprintf("a threshold is required, like `alerts add %s %d`", alert.Kind, map[string]int{alertTranscodes: 2, alertBandwidth: 100}[alert.Kind]))
+					return false
+				}
+
+				threshold, err := strconv.Atoi(args[2])
+
+				if err != nil || threshold < 0 {
+					commandList.showError(channelID, fmt.Sprintf("`%s` should be a number", args[2]))
+					return false
+				}
+
+				alert.Threshold = threshold
+
+			case alert4KTranscode:
+				if len(args) >= 3 {
+					user, _, err := resolvePlexUser(services, guildID, args[2])
+
+					if err != nil {
+						commandList.showError(channelID, err.Error())
+						return false
+					}
+
+					// rules are matched against sessions, which only know usernames
+					if strings.Contains(user, "@") {
+						link := services.getPlexLink(guildID)
+
+						if !link.authorized() {
+							commandList.showError(channelID, "dobby is not linked to a plex server -- run `link`")
+							return false
+						}
+
+						if user, err = plexUsername(link, user); err != nil {
+							commandList.showError(channelID, err.Error())
+							return false
+						}
+					}
+
+					alert.User = user
+				}
+
+			default:
+				commandList.showError(channelID, "alerts can be `transcodes`, `bandwidth` or `4k-transcode`")
+				return false
+			}
+
+			err := services.updateGuildSettings(guildID, func(settings *guildSettings) {
+				settings.SessionAlerts = append(settings.SessionAlerts, alert)
+			})
+
+			if err != nil {
+				fmt.Printf("alerts() - could not save settings: %v\n", err)
+				commandList.showError(channelID, "`internal error - could not save settings`")
+				return false
+			}
+
+			audit(guildID, m.Author.ID, "alert-add", fmt.Sprintf("rule=%q", alert.describe()))
+
+			message := fmt.Sprintf("admins will be alerted about %s", alert.describe())
+
+			if services.getGuildSettings(guildID).AdminChannelID == "" {
+				message += " -- set an admin channel with `settings admin-channel #channel` to see them"
+			}
+
+			commandList.discord.ChannelMessageSend(channelID, message)
+
+			return true
+
+		case args[0] == "remove" && len(args) >= 2:
+			number, err := strconv.Atoi(args[1])
+
+			if err != nil {
+				commandList.showError(channelID, fmt.Sprintf("`%s` should be the number of a rule in `alerts`", args[1]))
+				return false
+			}
+
+			var removed sessionAlert
+			found := false
+
+			err = services.updateGuildSettings(guildID, func(settings *guildSettings) {
+				if number < 1 || number > len(settings.SessionAlerts) {
+					return
+				}
+
+				removed = settings.SessionAlerts[number-1]
+				found = true
+				settings.SessionAlerts = append(settings.SessionAlerts[:number-1], settings.SessionAlerts[number:]...)
+			})
+
+			if err != nil {
+				fmt.Printf("alerts() - could not save settings: %v\n", err)
+				commandList.showError(channelID, "`internal error - could not save settings`")
+				return false
+			}
+
+			if !found {
+				commandList.showError(channelID, fmt.Sprintf("there is no rule %d -- see `alerts`", number))
+				return false
+			}
+
+			audit(guildID, m.Author.ID, "alert-remove", fmt.Sprintf("rule=%q", removed.describe()))
+
+			commandList.discord.ChannelMessageSend(channelID, fmt.Sprintf("admins will no longer be alerted about %s", removed.describe()))
+
+			return true
+		}
+
+		commandList.showError(channelID, usage)
+
+		return false
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// testSessions are what /status/sessions returns for four streams
+const testSessions = `{"MediaContainer": {"size": 4, "Metadata": [
+	{"sessionKey": "1", "User": {"title": "alice"}, "Session": {"id": "a1", "bandwidth": 20000},
+	 "Media": [{"videoResolution": "4k"}], "TranscodeSession": {"videoDecision": "transcode"}},
+	{"sessionKey": "2", "User": {"title": "Alice"}, "Session": {"id": "a2", "bandwidth": 8000},
+	 "Media": [{"videoResolution": "1080"}], "TranscodeSession": {"videoDecision": "copy", "audioDecision": "transcode"}},
+	{"sessionKey": "3", "User": {"title": "bob"}, "Session": {"id": "b1", "bandwidth": 15000},
+	 "Media": [{"videoResolution": "4k"}], "TranscodeSession": {"videoDecision": "transcode"}},
+	{"sessionKey": "4", "User": {"title": "carol"}, "Session": {"id": "c1", "bandwidth": 4000},
+	 "Media": [{"videoResolution": "4k"}]}
+]}}`
+
+func TestMatchAlert(t *testing.T) {
+	var container sessionContainer
+
+	if err := json.Unmarshal([]byte(testSessions), &container); err != nil {
+		t.Fatalf("could not read test sessions: %v", err)
+	}
+
+	sessions := container.MediaContainer.Metadata
+
+	tests := []struct {
+		name  string
+		alert sessionAlert
+		// want maps each cooldown target to the session ids that matched
+		want map[string][]string
+	}{
+		{
+			name:  "transcodes over the threshold",
+			alert: sessionAlert{Kind: alertTranscodes, Threshold: 2},
+			want:  map[string][]string{"": {"a1", "a2", "b1"}},
+		},
+		{
+			name:  "transcodes at the threshold",
+			alert: sessionAlert{Kind: alertTranscodes, Threshold: 3},
+			want:  map[string][]string{},
+		},
+		{
+			name:  "bandwidth over the threshold",
+			alert: sessionAlert{Kind: alertBandwidth, Threshold: 40},
+			want:  map[string][]string{"": {"a1", "a2", "b1", "c1"}},
+		},
+		{
+			name:  "bandwidth at the threshold",
+			alert: sessionAlert{Kind: alertBandwidth, Threshold: 47},
+			want:  map[string][]string{},
+		},
+		{
+			name:  "4k transcodes are grouped by user",
+			alert: sessionAlert{Kind: alert4KTranscode},
+			want:  map[string][]string{"alice": {"a1"}, "bob": {"b1"}},
+		},
+		{
+			name:  "4k transcodes of one user ignore case",
+			alert: sessionAlert{Kind: alert4KTranscode, User: "ALICE"},
+			want:  map[string][]string{"alice": {"a1"}},
+		},
+		{
+			name:  "4k direct play is fine",
+			alert: sessionAlert{Kind: alert4KTranscode, User: "carol"},
+			want:  map[string][]string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := map[string][]string{}
+
+			for target, matched := range matchAlert(test.alert, sessions) {
+				for _, s := range matched {
+					got[target] = append(got[target], s.Session.ID)
+				}
+
+				sort.Strings(got[target])
+			}
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("matchAlert() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
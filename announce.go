@@ -0,0 +1,381 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/jrudio/go-plex-client"
+)
+
+const (
+	// announceCheckInterval is how often we look for new media to announce
+	announceCheckInterval = 10 * time.Minute
+	// announceFetchSize is how many of the newest items of each library we look at
+	announceFetchSize = 50
+	// announcedKeys is how many announced items we remember to avoid announcing them twice
+	announcedKeys = 200
+	// announceMovieBatch is how many new movies of a channel get their own post, more are listed together
+	announceMovieBatch = 3
+	// announceMovieLines caps how many movies a listing shows
+	announceMovieLines = 15
+)
+
+// announceRoute sends announcements of one library to its own channel
+type announceRoute struct {
+	SectionKey   string `toml:"sectionKey"`
+	SectionTitle string `toml:"sectionTitle"`
+	ChannelID    string `toml:"channelID"`
+}
+
+// announceState is what a guild has already announced
+type announceState struct {
+	GuildID string `toml:"guildID"`
+	// LastAddedAt is when the newest announced item was added
+	LastAddedAt int64 `toml:"lastAddedAt"`
+	// RatingKeys are the items announced most recently, newest last
+	RatingKeys []string `toml:"ratingKeys"`
+}
+
+// announceChannel returns where an item of a library is announced, empty when it isn't
+func announceChannel(settings guildSettings, sectionKey string) string {
+	for _, route := range settings.AnnounceRoutes {
+		if route.SectionKey == sectionKey {
+			return route.ChannelID
+		}
+	}
+
+	return settings.AnnounceChannelID
+}
+
+// watchNewMedia periodically announces media added to the plex server
+func watchNewMedia(commandList d, services *clients) {
+	for {
+		time.Sleep(announceCheckInterval)
+
+		guilds := map[string]guildSettings{}
+
+		services.data.view(func(data dobbyData) {
+			for guildID, settings := range data.Guilds {
+				if settings.AnnounceChannelID != "" || len(settings.AnnounceRoutes) > 0 {
+					guilds[guildID] = settings
+				}
+			}
+		})
+
+		for guildID, settings := range guilds {
+			if err := announceNewMedia(commandList, services, guildID, settings); err != nil {
+				fmt.Printf("watchNewMedia() - guild %s: %v\n", guildID, err)
+			}
+		}
+	}
+}
+
+// announceNewMedia posts what was added since the last check
+//
+// the first check only remembers what is there so turning announcements on doesn't flood the channel
+func announceNewMedia(commandList d, services *clients, guildID string, settings guildSettings) error {
+	link := services.getPlexLink(guildID)
+
+	if !link.authorized() {
+		return nil
+	}
+
+	sections, err := getLibrarySections(link.client())
+
+	if err != nil {
+		return err
+	}
+
+	items, err := getRecentlyAdded(link.client(), sections, announceFetchSize)
+
+	if err != nil {
+		return err
+	}
+
+	var state announceState
+	known := false
+
+	services.data.view(func(data dobbyData) {
+		for _, s := range data.Announced {
+			if s.GuildID == guildID {
+				state = s
+				known = true
+			}
+		}
+	})
+
+	state.GuildID = guildID
+
+	announced := map[string]bool{}
+
+	for _, key := range state.RatingKeys {
+		announced[key] = true
+	}
+
+	fresh := []mediaItem{}
+
+	for _, item := range items {
+		if item.AddedAt < state.LastAddedAt || announced[item.RatingKey] {
+			continue
+		}
+
+		fresh = append(fresh, item)
+	}
+
+	if len(fresh) == 0 && known {
+		return nil
+	}
+
+	// oldest first so the channel reads in order
+	sort.SliceStable(fresh, func(i, j int) bool {
+		return fresh[i].AddedAt < fresh[j].AddedAt
+	})
+
+	if known {
+		machineID, err := link.client().GetMachineID()
+
+		if err != nil {
+			return err
+		}
+
+		byChannel := map[string][]mediaItem{}
+		channels := []string{}
+
+		for _, item := range fresh {
+			channelID := announceChannel(settings, fmt.Sprint(item.LibrarySectionID))
+
+			if channelID == "" {
+				continue
+			}
+
+			if _, ok := byChannel[channelID]; !ok {
+				channels = append(channels, channelID)
+			}
+
+			byChannel[channelID] = append(byChannel[channelID], item)
+		}
+
+		for _, channelID := range channels {
+			groups, movies := splitMovies(groupByShow(byChannel[channelID]))
+
+			// a big scan would flood the channel with a post per movie
+			if len(movies) > announceMovieBatch {
+				groups = append(groups, movies)
+			} else {
+				for _, movie := range movies {
+					groups = append(groups, []mediaItem{movie})
+				}
+			}
+
+			for _, group := range groups {
+				embed, poster := announceEmbed(group, machineID)
+
+				if err := sendMediaEmbed(commandList, link.client(), channelID, embed, poster); err != nil {
+					fmt.Printf("announceNewMedia() - message sent to discord failed: %v\n", err)
+				}
+			}
+		}
+	}
+
+	for _, item := range fresh {
+		if item.AddedAt > state.LastAddedAt {
+			state.LastAddedAt = item.AddedAt
+		}
+
+		state.RatingKeys = append(state.RatingKeys, item.RatingKey)
+	}
+
+	if len(state.RatingKeys) > announcedKeys {
+		state.RatingKeys = state.RatingKeys[len(state.RatingKeys)-announcedKeys:]
+	}
+
+	return services.data.update(func(data *dobbyData) {
+		for i := range data.Announced {
+			if data.Announced[i].GuildID == guildID {
+				data.Announced[i] = state
+				return
+			}
+		}
+
+		data.Announced = append(data.Announced, state)
+	})
+}
+
+// splitMovies takes the movies out of groups made by groupByShow
+func splitMovies(groups [][]mediaItem) ([][]mediaItem, []mediaItem) {
+	others := [][]mediaItem{}
+	movies := []mediaItem{}
+
+	for _, group := range groups {
+		if group[0].Type == "movie" {
+			movies = append(movies, group[0])
+			continue
+		}
+
+		others = append(others, group)
+	}
+
+	return others, movies
+}
+
+// announceEmbed describes a group of new media, listing several movies in one embed
+func announceEmbed(group []mediaItem, machineID string) (*discordgo.MessageEmbed, string) {
+	if len(group) < 2 || group[0].Type != "movie" {
+		embed, poster := groupEmbed(group, machineID)
+		embed.Author = &discordgo.MessageEmbedAuthor{Name: "New on Plex"}
+
+		return embed, poster
+	}
+
+	lines := []string{}
+
+	for i, movie := range group {
+		if i == announceMovieLines {
+			lines = append(lines, fmt.Sprintf("...and %d more", len(group)-announceMovieLines))
+			break
+		}
+
+		lines = append(lines, fmt.Sprintf("[%s](%s)", movie.name(), movie.link(machineID)))
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Author:      &discordgo.MessageEmbedAuthor{Name: "New on Plex"},
+		Title:       fmt.Sprintf("%d new movies", len(group)),
+		Description: strings.Join(lines, "\n"),
+	}
+
+	return embed, group[0].poster()
+}
+
+// announce picks the channels new media is announced in
+//
+// command: announce | announce <#channel|off> [--library Movies]
+func announce(commandList d, services *clients) func(m *discordgo.Message, args ...string) bool {
+	return func(m *discordgo.Message, args ...string) bool {
+		channelID := m.ChannelID
+		guildID := commandList.getGuildID(channelID)
+
+		if guildID == "" {
+			commandList.showError(channelID, "announcements can only be set up from a server channel")
+			return false
+		}
+
+		args, options, err := parseOptions(args, optionList{"library": true})
+
+		if err != nil {
+			commandList.showError(channelID, err.Error())
+			return false
+		}
+
+		if len(args) < 1 {
+			settings := services.getGuildSettings(guildID)
+
+			message := "New media is announced in "
+
+			if settings.AnnounceChannelID == "" {
+				message += "no channel"
+			} else {
+				message += "<#" + settings.AnnounceChannelID + ">"
+			}
+
+			for _, route := range settings.AnnounceRoutes {
+				message += fmt.Sprintf("\n%s is announced in <#%s>", route.SectionTitle, route.ChannelID)
+			}
+
+			message += "\nuse `announce #channel [--library Movies]` or `announce off [--library Movies]` to change it"
+
+			commandList.discord.ChannelMessageSend(channelID, message)
+
+			return true
+		}
+
+		target := ""
+
+		if args[0] != "off" {
+			var ok bool
+
+			if target, ok = parseChannelMention(args[0]); !ok {
+				commandList.showError(channelID, fmt.Sprintf("`%s` is not a channel -- use `#channel` or `off`", args[0]))
+				return false
+			}
+		}
+
+		var section plex.Directory
+
+		libraryName, perLibrary := options["library"]
+
+		if perLibrary {
+			link := services.getPlexLink(guildID)
+
+			if !link.authorized() {
+				commandList.showError(channelID, "dobby is not linked to a plex server -- run `link`")
+				return false
+			}
+
+			sections, err := getLibrarySections(link.client())
+
+			if err != nil {
+				fmt.Printf("announce() - could not fetch libraries: %v\n", err)
+				commandList.showError(channelID, "could not fetch the libraries of your plex server")
+				return false
+			}
+
+			if section, err = findLibrarySection(sections, libraryName); err != nil {
+				commandList.showError(channelID, err.Error())
+				return false
+			}
+		}
+
+		err = services.updateGuildSettings(guildID, func(settings *guildSettings) {
+			if !perLibrary {
+				settings.AnnounceChannelID = target
+				return
+			}
+
+			routes := settings.AnnounceRoutes[:0]
+
+			for _, route := range settings.AnnounceRoutes {
+				if route.SectionKey != section.Key {
+					routes = append(routes, route)
+				}
+			}
+
+			if target != "" {
+				routes = append(routes, announceRoute{SectionKey: section.Key, SectionTitle: section.Title, ChannelID: target})
+			}
+
+			settings.AnnounceRoutes = routes
+		})
+
+		if err != nil {
+			fmt.Printf("announce() - could not save settings: %v\n", err)
+			commandList.showError(channelID, "`internal error - could not save settings`")
+			return false
+		}
+
+		what := "new media"
+
+		if perLibrary {
+			what = "new media in " + section.Title
+		}
+
+		if target == "" {
+			message := what + " is no longer announced"
+
+			if perLibrary {
+				message = what + " is announced in the default channel again"
+			}
+
+			commandList.discord.ChannelMessageSend(channelID, message)
+
+			return true
+		}
+
+		commandList.discord.ChannelMessageSend(channelID, fmt.Sprintf("%s will be announced in <#%s>", what, target))
+
+		return true
+	}
+}
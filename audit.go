@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+const auditFilepath = "./audit.log"
+
+var auditLock sync.Mutex
+
+// audit records an action taken against a plex server in audit.log
+//
+// guildID is empty for the default plex server
+func audit(guildID, actorID, action, details string) {
+	if guildID == "" {
+		guildID = "-"
+	}
+
+	line := fmt.Sprintf("%s guild=%s actor=%s action=%s %s\n",
+		time.Now().Format(time.RFC3339),
+		guildID,
+		actorID,
+		action,
+		details)
+
+	if isVerbose {
+		fmt.Print("audit: " + line)
+	}
+
+	auditLock.Lock()
+	defer auditLock.Unlock()
+
+	f, err := os.OpenFile(auditFilepath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+
+	if err != nil {
+		fmt.Printf("audit() - could not open %s: %v\n", auditFilepath, err)
+		return
+	}
+
+	defer f.Close()
+
+	if _, err := f.WriteString(line); err != nil {
+		fmt.Printf("audit() - could not write to %s: %v\n", auditFilepath, err)
+	}
+}
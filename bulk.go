@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/jrudio/go-plex-client"
+)
+
+const (
+	// bulkMaxFileSize keeps us from downloading anything that can't be a list of invites
+	bulkMaxFileSize = 1 << 20
+	// bulkProgressEvery is how many rows are processed between progress updates
+	bulkProgressEvery = 5
+	// bulkInviteDelay spaces out invites so plex.tv does not rate limit us
+	bulkInviteDelay = time.Second
+	// bulkSummaryLines caps how many rows the dry run lists
+	bulkSummaryLines = 20
+)
+
+// bulkRow is one line of an invite-bulk csv: user, libraries, expiry
+type bulkRow struct {
+	line      int
+	user      string
+	libraries []string
+	expires   string
+	length    time.Duration
+	// invalid explains why the row will be skipped
+	invalid string
+}
+
+// parseBulkCSV reads and validates the rows of an invite-bulk csv
+//
+// libraries are separated by ; or , and may be empty to share all of them.
+// an optional header row is skipped
+func parseBulkCSV(r io.Reader, sections []plex.ServerSections) ([]bulkRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	records, err := reader.ReadAll()
+
+	if err != nil {
+		return nil, err
+	}
+
+	rows := []bulkRow{}
+	seen := map[string]bool{}
+
+	for i, record := range records {
+		row := bulkRow{line: i + 1}
+
+		if len(record) > 0 {
+			row.user = strings.TrimSpace(record[0])
+		}
+
+		if i == 0 && containsFold([]string{"email", "username", "user", "email/username", "username/email"}, row.user) {
+			continue
+		}
+
+		if len(record) > 1 {
+			row.libraries = splitLibraries(strings.Replace(record[1], ";", ",", -1))
+		}
+
+		if len(record) > 2 {
+			row.expires = strings.TrimSpace(record[2])
+		}
+
+		switch {
+		case row.user == "" && len(row.libraries) == 0 && row.expires == "":
+			continue
+		case row.user == "":
+			row.invalid = "no username or email"
+		case seen[strings.ToLower(row.user)]:
+			row.invalid = "listed more than once"
+		}
+
+		seen[strings.ToLower(row.user)] = true
+
+		if row.invalid == "" {
+			if err := validateInviteeSyntax(row.user); err != nil {
+				row.invalid = skipReason(err)
+			}
+		}
+
+		if row.invalid == "" {
+			if _, err := matchLibraries(sections, row.libraries); err != nil {
+				row.invalid = skipReason(err)
+			}
+		}
+
+		if row.invalid == "" && row.expires != "" {
+			if row.length, err = parseDuration(row.expires); err != nil {
+				row.invalid = skipReason(err)
+			}
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// skipReason fits an error on the summary line of a skipped row, which already quotes the user
+func skipReason(err error) string {
+	return strings.NewReplacer("`", "", "\n", " -- ").Replace(err.Error())
+}
+
+// downloadAttachment fetches a file attached to a discord message
+func downloadAttachment(attachment *discordgo.MessageAttachment) ([]byte, error) {
+	if attachment.Size > bulkMaxFileSize {
+		return nil, fmt.Errorf("%s is too big", attachment.Filename)
+	}
+
+	client := http.Client{Timeout: 30 * time.Second}
+
+	resp, err := client.Get(attachment.URL)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(resp.Status)
+	}
+
+	return ioutil.ReadAll(io.LimitReader(resp.Body, bulkMaxFileSize))
+}
+
+// inviteBulk invites everyone in an attached csv after showing what it will do
+//
+// command: invite-bulk [--dry-run] with a csv attached: username or email, libraries, expiry
+func inviteBulk(commandList d, services *clients) func(m *discordgo.Message, args ...string) bool {
+	return func(m *discordgo.Message, args ...string) bool {
+		channelID := m.ChannelID
+		guildID := commandList.getGuildID(channelID)
+		link := services.getPlexLink(guildID)
+
+		if !link.authorized() {
+			commandList.showError(channelID, "dobby is not linked to a plex server -- run `link`")
+			return false
+		}
+
+		_, options, err := parseOptions(args, optionList{"dry-run": false})
+
+		if err != nil {
+			commandList.showError(channelID, err.Error())
+			return false
+		}
+
+		if len(m.Attachments) < 1 {
+			commandList.showError(channelID, "attach a csv with one invite per line: `username or email, libraries, expiry` -- like `bob@example.com, Movies;TV, 14d`")
+			return false
+		}
+
+		file, err := downloadAttachment(m.Attachments[0])
+
+		if err != nil {
+			fmt.Printf("inviteBulk() - could not download attachment: %v\n", err)
+			commandList.showError(channelID, fmt.Sprintf("could not read the attachment: %v", err))
+			return false
+		}
+
+		machineID, err := link.client().GetMachineID()
+
+		if err != nil {
+			fmt.Printf("inviteBulk() - could not fetch machine id: %v\n", err)
+			commandList.showError(channelID, "dobby error - could not get machine id from plex server")
+			return false
+		}
+
+		sections, err := link.client().GetSections(machineID)
+
+		if err != nil {
+			fmt.Printf("inviteBulk() - could not fetch libraries: %v\n", err)
+			commandList.showError(channelID, "could not fetch the libraries of your plex server")
+			return false
+		}
+
+		rows, err := parseBulkCSV(bytes.NewReader(file), sections)
+
+		if err != nil {
+			commandList.showError(channelID, fmt.Sprintf("could not read the csv: %v", err))
+			return false
+		}
+
+		valid := 0
+		summary := ""
+
+		for i, row := range rows {
+			if row.invalid == "" {
+				valid++
+			}
+
+			if i >= bulkSummaryLines {
+				continue
+			}
+
+			if row.invalid != "" {
+				summary += fmt.Sprintf("line %d `%s`: skipped, %s\n", row.line, row.user, row.invalid)
+				continue
+			}
+
+			summary += fmt.Sprintf("line %d `%s`: %s", row.line, row.user, describeSharing(sharingSettings{libraries: row.libraries}))
+
+			if row.expires != "" {
+				summary += ", expires after " + row.expires
+			}
+
+			summary += "\n"
+		}
+
+		if len(rows) > bulkSummaryLines {
+			summary += fmt.Sprintf("...and %d more\n", len(rows)-bulkSummaryLines)
+		}
+
+		summary = fmt.Sprintf("%d rows, %d will be invited, %d skipped:\n", len(rows), valid, len(rows)-valid) + summary
+
+		if _, dryRun := options["dry-run"]; dryRun || valid == 0 {
+			if err := commandList.sendLongMessage(channelID, summary); err != nil {
+				fmt.Printf("inviteBulk() - message sent to discord failed: %v\n", err)
+			}
+
+			return true
+		}
+
+		askConfirmation(commandList, m, summary+fmt.Sprintf("send %d invites?", valid), func() {
+			processBulkInvites(commandList, services, m, link, rows)
+		})
+
+		return true
+	}
+}
+
+// processBulkInvites sends the invites of valid rows, posting progress and a result file
+func processBulkInvites(commandList d, services *clients, m *discordgo.Message, link *plexLink, rows []bulkRow) {
+	channelID := m.ChannelID
+	guildID := commandList.getGuildID(channelID)
+
+	var results bytes.Buffer
+
+	report := csv.NewWriter(&results)
+	report.Write([]string{"line", "user", "libraries", "expires", "result", "details"})
+
+	total := 0
+
+	for _, row := range rows {
+		if row.invalid == "" {
+			total++
+		}
+	}
+
+	// fetch who we share with once instead of for every row
+	shared := []sharedServer{}
+
+	if machineID, err := link.client().GetMachineID(); err != nil {
+		fmt.Printf("processBulkInvites() - could not fetch machine id: %v\n", err)
+	} else if shared, err = getSharedServers(link.client(), machineID); err != nil {
+		fmt.Printf("processBulkInvites() - could not fetch shared users: %v\n", err)
+		shared = []sharedServer{}
+	}
+
+	progress, _ := commandList.discord.ChannelMessageSend(channelID, fmt.Sprintf("inviting 0/%d", total))
+
+	done, invited, failed := 0, 0, 0
+
+	for _, row := range rows {
+		record := []string{fmt.Sprint(row.line), row.user, strings.Join(row.libraries, ";"), row.expires}
+
+		if row.invalid != "" {
+			report.Write(append(record, "skipped", row.invalid))
+			continue
+		}
+
+		if done > 0 {
+			time.Sleep(bulkInviteDelay)
+		}
+
+		err := sendInviteWith(link, shared, row.user, sharingSettings{libraries: row.libraries})
+
+		done++
+
+		if err != nil {
+			failed++
+			report.Write(append(record, "failed", err.Error()))
+		} else {
+			invited++
+
+			trackInvite(services, trackedInvite{
+				GuildID:         guildID,
+				ChannelID:       channelID,
+				InvitedBy:       m.Author.ID,
+				UsernameOrEmail: row.user,
+				InvitedAt:       time.Now(),
+			})
+
+			details := ""
+
+			if row.length > 0 {
+				t := newTrial(guildID, channelID, "", row.user, row.length)
+
+				if err := services.data.update(func(data *dobbyData) {
+					data.Trials = append(data.Trials, t)
+				}); err != nil {
+					fmt.Printf("processBulkInvites() - could not save trial: %v\n", err)
+					details = "could not save the expiry, access will not expire"
+				} else {
+					details = "expires " + t.ExpiresAt.Format(time.RFC3339)
+				}
+			}
+
+			report.Write(append(record, "invited", details))
+		}
+
+		if progress != nil && (done%bulkProgressEvery == 0 || done == total) {
+			commandList.discord.ChannelMessageEdit(channelID, progress.ID, fmt.Sprintf("inviting %d/%d", done, total))
+		}
+	}
+
+	report.Flush()
+
+	audit(guildID, m.Author.ID, "invite-bulk", fmt.Sprintf("invited=%d failed=%d skipped=%d", invited, failed, len(rows)-total))
+
+	message := fmt.Sprintf("bulk invite done: %d invited, %d failed, %d skipped", invited, failed, len(rows)-total)
+
+	if _, err := commandList.discord.ChannelFileSendWithMessage(channelID, message, "invite-results.csv", &results); err != nil {
+		fmt.Printf("processBulkInvites() - could not send results: %v\n", err)
+		commandList.discord.ChannelMessageSend(channelID, message+" (could not attach the results)")
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jrudio/go-plex-client"
+)
+
+func TestParseBulkCSV(t *testing.T) {
+	sections := []plex.ServerSections{{ID: 1, Title: "Movies"}, {ID: 2, Title: "TV Shows "}}
+
+	tests := []struct {
+		name    string
+		csv     string
+		want    []bulkRow
+		wantErr bool
+	}{
+		{
+			name: "user, libraries and expiry",
+			csv:  "bob@example.com, Movies;tv shows, 14d\nalice",
+			want: []bulkRow{
+				{line: 1, user: "bob@example.com", libraries: []string{"Movies", "tv shows"}, expires: "14d", length: 14 * 24 * time.Hour},
+				{line: 2, user: "alice"},
+			},
+		},
+		{
+			name: "header and blank rows are skipped",
+			csv:  "email,libraries,expires\n,,\nbob,\"Movies,TV Shows\",",
+			want: []bulkRow{
+				{line: 3, user: "bob", libraries: []string{"Movies", "TV Shows"}},
+			},
+		},
+		{
+			name: "invalid rows say why",
+			csv:  ",Movies\nbob\nBOB\nnot an email@\ncarol,Music\ndave,,soon",
+			want: []bulkRow{
+				{line: 1, libraries: []string{"Movies"}, invalid: "no username or email"},
+				{line: 2, user: "bob"},
+				{line: 3, user: "BOB", invalid: "listed more than once"},
+				{line: 4, user: "not an email@", invalid: "not an email@ is not a valid email address"},
+				{line: 5, user: "carol", libraries: []string{"Music"}, invalid: "unknown libraries: Music -- available libraries: Movies, TV Shows"},
+				{line: 6, user: "dave", expires: "soon", invalid: "soon is not a duration -- use something like 12h, 14d or 2w"},
+			},
+		},
+		{
+			name:    "broken csv",
+			csv:     "\"bob",
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rows, err := parseBulkCSV(strings.NewReader(test.csv), sections)
+
+			if (err != nil) != test.wantErr {
+				t.Fatalf("parseBulkCSV() error = %v, wantErr %v", err, test.wantErr)
+			}
+
+			if test.wantErr {
+				return
+			}
+
+			// an empty libraries column and a missing one both share everything
+			for i := range rows {
+				if len(rows[i].libraries) == 0 {
+					rows[i].libraries = nil
+				}
+			}
+
+			if !reflect.DeepEqual(rows, test.want) {
+				t.Errorf("parseBulkCSV() =\n%+v\nwant\n%+v", rows, test.want)
+			}
+		})
+	}
+}
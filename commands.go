@@ -1,8 +1,10 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
@@ -10,29 +12,55 @@ import (
 )
 
 type plexCommands struct {
-	hooks []func(channelID string, args ...string) bool
+	hooks []func(m *discordgo.Message, args ...string) bool
 }
 
 type d struct {
-	cmds    map[string][]func(channelID string, args ...string) bool
+	cmds    map[string][]func(m *discordgo.Message, args ...string) bool
 	discord *discordgo.Session
+	// lock guards cmds as commands can be re-registered on reload
+	lock *sync.RWMutex
 }
 
 func newDiscord(session *discordgo.Session) d {
 	return d{
-		cmds:    map[string][]func(channelID string, args ...string) bool{},
+		cmds:    map[string][]func(m *discordgo.Message, args ...string) bool{},
 		discord: session,
+		lock:    &sync.RWMutex{},
 	}
 }
 
-func (discord d) addCommand(cmd string, fn ...func(channelID string, args ...string) bool) {
+func (discord d) addCommand(cmd string, fn ...func(m *discordgo.Message, args ...string) bool) {
+	discord.lock.Lock()
 	discord.cmds[cmd] = fn
+	discord.lock.Unlock()
 }
 
-func (discord d) execute(channelID, cmd string, args ...string) {
-	if functions, ok := discord.cmds[cmd]; ok {
+// replaceCommands swaps every registered command for the ones registered on registry
+func (discord d) replaceCommands(registry d) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+
+	discord.lock.Lock()
+	defer discord.lock.Unlock()
+
+	for cmd := range discord.cmds {
+		delete(discord.cmds, cmd)
+	}
+
+	for cmd, fn := range registry.cmds {
+		discord.cmds[cmd] = fn
+	}
+}
+
+func (discord d) execute(m *discordgo.Message, cmd string, args ...string) {
+	discord.lock.RLock()
+	functions, ok := discord.cmds[cmd]
+	discord.lock.RUnlock()
+
+	if ok {
 		for _, fn := range functions {
-			if _ok := fn(channelID, args...); !_ok {
+			if _ok := fn(m, args...); !_ok {
 				// stop subsequent commands if current function returns false
 				break
 			}
@@ -45,7 +73,9 @@ func (discord d) execute(channelID, cmd string, args ...string) {
 }
 
 func (discord d) isValid(cmd string) bool {
+	discord.lock.RLock()
 	_, ok := discord.cmds[cmd]
+	discord.lock.RUnlock()
 
 	return ok
 }
@@ -53,7 +83,7 @@ func (discord d) isValid(cmd string) bool {
 func (discord d) showHelp(channelID string) {
 	msg := "Here is a list of available commands: \n"
 
-	for key := range discord.cmds {
+	for _, key := range discord.getCommands() {
 		msg += "`" + key + "`\n"
 	}
 
@@ -67,6 +97,9 @@ func (discord d) showHelp(channelID string) {
 }
 
 func (discord d) getCommands() []string {
+	discord.lock.RLock()
+	defer discord.lock.RUnlock()
+
 	cmdsLen := len(discord.cmds)
 
 	cmds := make([]string, cmdsLen)
@@ -84,6 +117,48 @@ func (discord d) getCommands() []string {
 	return cmds
 }
 
+// getGuildID returns the guild a channel belongs to, or an empty string for direct messages
+func (discord d) getGuildID(channelID string) string {
+	channel, err := discord.discord.State.Channel(channelID)
+
+	if err != nil {
+		// not cached yet
+		if channel, err = discord.discord.Channel(channelID); err != nil {
+			if isVerbose {
+				fmt.Printf("getGuildID() - could not fetch channel %s: %v\n", channelID, err)
+			}
+
+			return ""
+		}
+	}
+
+	return channel.GuildID
+}
+
+// sendDM sends a direct message to a discord user
+func (discord d) sendDM(userID, msg string) error {
+	channel, err := discord.discord.UserChannelCreate(userID)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = discord.discord.ChannelMessageSend(channel.ID, msg)
+
+	return err
+}
+
+// sendLongMessage sends a message that may be over discord's length limit as several messages
+func (discord d) sendLongMessage(channelID, msg string) error {
+	for _, part := range splitMessage(msg, maxMessageLength) {
+		if _, err := discord.discord.ChannelMessageSend(channelID, part); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (discord d) showError(channelID, msg string) {
 	_, err := discord.discord.ChannelMessageSend(channelID, msg)
 
@@ -92,8 +167,65 @@ func (discord d) showError(channelID, msg string) {
 	}
 }
 
-func clearMessages(commandList d, services *clients) func(channelID string, args ...string) bool {
-	return func(channelID string, args ...string) bool {
+// ownerOnly stops the command unless it was sent by dobby's owner
+func ownerOnly(commandList d, services *clients) func(m *discordgo.Message, args ...string) bool {
+	return func(m *discordgo.Message, args ...string) bool {
+		if isOwner(services, m.Author.ID) {
+			return true
+		}
+
+		if isVerbose {
+			fmt.Printf("ownerOnly() - %s is not the owner\n", m.Author.ID)
+		}
+
+		commandList.showError(m.ChannelID, "only dobby's owner can use that command")
+
+		return false
+	}
+}
+
+// adminOnly stops the command unless it was sent by dobby's owner or a guild administrator
+func adminOnly(commandList d, services *clients) func(m *discordgo.Message, args ...string) bool {
+	return func(m *discordgo.Message, args ...string) bool {
+		if isAdmin(commandList, services, m.Author.ID, m.ChannelID) {
+			return true
+		}
+
+		if isVerbose {
+			fmt.Printf("adminOnly() - %s is not an admin\n", m.Author.ID)
+		}
+
+		commandList.showError(m.ChannelID, "only admins can use that command")
+
+		return false
+	}
+}
+
+func isAdmin(commandList d, services *clients, userID, channelID string) bool {
+	if isOwner(services, userID) {
+		return true
+	}
+
+	permissions, err := commandList.discord.State.UserChannelPermissions(userID, channelID)
+
+	if err != nil {
+		if permissions, err = commandList.discord.UserChannelPermissions(userID, channelID); err != nil {
+			return false
+		}
+	}
+
+	return permissions&discordgo.PermissionAdministrator == discordgo.PermissionAdministrator
+}
+
+func isOwner(services *clients, userID string) bool {
+	ownerID := services.getConfig().OwnerID
+
+	return ownerID != "" && ownerID == userID
+}
+
+func clearMessages(commandList d, services *clients) func(m *discordgo.Message, args ...string) bool {
+	return func(m *discordgo.Message, args ...string) bool {
+		channelID := m.ChannelID
 		argCount := len(args)
 		messageLimit := 0
 
@@ -135,29 +267,36 @@ func clearMessages(commandList d, services *clients) func(channelID string, args
 	}
 }
 
-func displayPlexPIN(commandList d, services *clients) func(channelID string, args ...string) bool {
-	return func(channelID string, args ...string) bool {
-		if isPlexTokenAuthorized {
+func displayPlexPIN(commandList d, services *clients) func(m *discordgo.Message, args ...string) bool {
+	return func(m *discordgo.Message, args ...string) bool {
+		channelID := m.ChannelID
+		link := services.getPlexLink(commandList.getGuildID(channelID))
+
+		if link.authorized() {
 			if isVerbose {
 				fmt.Println("displayPlexPIN() - dobby is already authorized")
 			}
 			return true
 		}
 
-		if isRequestingPlexPIN {
+		// whoever enters the PIN decides which plex account dobby uses
+		if !isAdmin(commandList, services, m.Author.ID, channelID) {
+			commandList.showError(channelID, "dobby is not linked to a plex server -- ask an admin to run `link`")
+			return false
+		}
+
+		if !link.startPINRequest() {
 			return true
 		}
 
 		message := "Dobby is not authorized to access your Plex Media Server\n"
 
-		isRequestingPlexPIN = true
-
-		requestHeaders := services.plex.Headers
+		requestHeaders := link.client().Headers
 
 		resp, err := plex.RequestPIN(requestHeaders)
 
 		if err != nil {
-			isRequestingPlexPIN = false
+			link.finishPINRequest()
 			return false
 		}
 
@@ -167,54 +306,96 @@ func displayPlexPIN(commandList d, services *clients) func(channelID string, arg
 
 		checkPlexPIN(resp, func(plexAuthToken string) {
 			// when we are authorized
+			defer link.finishPINRequest()
 
-			services.setPlexToken(plexAuthToken)
+			if err := link.link(plexCredentials{Token: plexAuthToken}); err != nil {
+				fmt.Printf("checkPlexPIN() - %v\n", err)
+				commandList.showError(channelID, "could not link Dobby: the plex account could not be checked, try `link` again")
+				return
+			}
+
+			if !link.authorized() {
+				commandList.showError(channelID, "could not link Dobby: no Plex Media Server of that account accepted the token")
+				return
+			}
 
 			message = "Successfully linked Dobby! :D"
 
 			commandList.discord.ChannelMessageSend(channelID, message)
 
-			isPlexTokenAuthorized = true
-
 			// persist plex auth token
-			creds, err := getCredentialsTOML(secretsFilepath)
+			tokenUpdate := credentialUpdate{table: link.credentialsTable(), key: "token", value: plexAuthToken, secret: true}
 
-			if err != nil {
-				fmt.Printf("checkPlexPIN() - error getting credentials: %v\n", err)
+			if err := updateCredentials(secretsFilepath, tokenUpdate); err != nil {
+				fmt.Printf("checkPlexPIN() - updateCredentials failed: %v\n", err)
 				message = "`internal error - could not save plex authorization token`"
 				commandList.discord.ChannelMessageSend(channelID, message)
-				isRequestingPlexPIN = false
 				return
 			}
 
-			creds.Plex.Token = plexAuthToken
-
-			if err := saveCredentials(creds, secretsFilepath); err != nil {
-				fmt.Printf("checkPlexPIN() - saveCredentials failed: %v\n", err)
-				message = "`internal error - could not save plex authorization token`"
-				commandList.discord.ChannelMessageSend(channelID, message)
-				isRequestingPlexPIN = false
-				return
-			}
+			services.updatePlexCredentials(link, func(credentials *plexCredentials) {
+				credentials.Token = plexAuthToken
+			})
 
 			if isVerbose {
 				fmt.Println("saved plex auth token to file")
 			}
-
-			isRequestingPlexPIN = false
 		}, func(errMessage string) {
 			// when we encounter an error
 			message = fmt.Sprintf("we have encountered an error :frowning2: :\n%v", errMessage)
 
 			commandList.discord.ChannelMessageSend(channelID, message)
 
-			isRequestingPlexPIN = false
+			link.finishPINRequest()
 		})
 
 		return false
 	}
 }
 
+// sendInvite invites usernameOrEmail to the plex server of link
+//
+// the returned error is meant to be shown in discord
+func sendInvite(link *plexLink, usernameOrEmail string, settings sharingSettings) error {
+	return sendInviteWith(link, nil, usernameOrEmail, settings)
+}
+
+// sendInviteWith is sendInvite for callers that already fetched the users we share with.
+// a nil shared is fetched from plex.tv
+func sendInviteWith(link *plexLink, shared []sharedServer, usernameOrEmail string, settings sharingSettings) error {
+	machineID, err := link.client().GetMachineID()
+
+	if err != nil {
+		fmt.Printf("sendInvite() - could not fetch machine id: %v\n", err)
+		return errors.New("dobby error - could not get machine id from plex server")
+	}
+
+	if isVerbose {
+		fmt.Println("machine id:", machineID)
+	}
+
+	if err := validateInvitee(link, machineID, shared, usernameOrEmail); err != nil {
+		return err
+	}
+
+	libraryIDs, err := resolveLibraries(link.client(), machineID, settings.libraries)
+
+	if err != nil {
+		fmt.Printf("sendInvite() - could not resolve libraries: %v\n", err)
+		return err
+	}
+
+	if err := inviteFriend(link.client(), machineID, usernameOrEmail, libraryIDs, settings); err != nil {
+		if isVerbose {
+			fmt.Printf("sendInvite() - inviteFriend failed: %v\n", err)
+		}
+
+		return describeInviteError(usernameOrEmail, err)
+	}
+
+	return nil
+}
+
 // checkPlexPIN is a loop to check if we are authorized to access a Plex server
 func checkPlexPIN(_plexPIN plex.PinResponse, onSuccess func(plexAuthToken string), onError func(errMessage string)) {
 	// TODO: check expiration
@@ -251,14 +432,33 @@ func checkPlexPIN(_plexPIN plex.PinResponse, onSuccess func(plexAuthToken string
 }
 
 // invite invite a plex user to your Plex Media Server
-func invite(commandList d, services *clients) func(channelID string, args ...string) bool {
-	return func(channelID string, args ...string) bool {
-		if !isPlexTokenAuthorized {
+//
+// command: invite <username|email> [@member] [--libraries Movies,TV] [--allow-sync=false] [--filter-movies "label=kids"] [--expires 14d]
+func invite(commandList d, services *clients) func(m *discordgo.Message, args ...string) bool {
+	return func(m *discordgo.Message, args ...string) bool {
+		channelID := m.ChannelID
+		guildID := commandList.getGuildID(channelID)
+		link := services.getPlexLink(guildID)
+		admin := isAdmin(commandList, services, m.Author.ID, channelID)
+
+		if services.getGuildSettings(guildID).RequireApproval && !admin {
+			commandList.showError(channelID, "invites need an admin's approval here -- use `request-access <email>`")
+			return false
+		}
+
+		if !link.authorized() {
 			fmt.Println("invite() - dobby is not authorized")
 			commandList.discord.ChannelMessageSend(channelID, "dobby is not authorized to send invites!")
 			return false
 		}
 
+		args, options, err := parseOptions(args, sharingOptions.with(optionList{"expires": true}))
+
+		if err != nil {
+			commandList.showError(channelID, err.Error())
+			return false
+		}
+
 		if len(args) < 1 {
 			if isVerbose {
 				fmt.Println("invite() - need a username or an email to invite user to our plex server")
@@ -269,217 +469,98 @@ func invite(commandList d, services *clients) func(channelID string, args ...str
 			return false
 		}
 
-		commandList.discord.ChannelMessageSend(channelID, "inviting user to our Plex Media Server")
+		// the discord member that gets linked to the invited plex account
+		memberID := m.Author.ID
+
+		if len(args) > 1 {
+			userID, ok := parseMention(args[1])
+
+			if !ok {
+				commandList.showError(channelID, fmt.Sprintf("`%s` is not a discord member -- use `invite <username|email> [@member]`", args[1]))
+				return false
+			}
+
+			if userID != m.Author.ID && !admin {
+				commandList.showError(channelID, "only admins can invite someone else")
+				return false
+			}
+
+			memberID = userID
+		}
 
-		machineID, err := services.plex.GetMachineID()
+		settings, err := parseSharingSettings(options)
 
 		if err != nil {
-			fmt.Printf("invite() - could not fetch machine id: %v\n", err)
-			commandList.showError(channelID, "dobby error - could not get machine id from plex server")
+			commandList.showError(channelID, err.Error())
 			return false
 		}
 
-		fmt.Println("machine id:", machineID)
+		var trialLength time.Duration
 
-		usernameOrEmail := args[0]
+		if expires, ok := options["expires"]; ok {
+			if trialLength, err = parseDuration(expires); err != nil {
+				commandList.showError(channelID, err.Error())
+				return false
+			}
+		}
 
-		params := plex.InviteFriendParams{
-			UsernameOrEmail: usernameOrEmail,
-			MachineID:       machineID,
+		if !admin {
+			if err := claimQuota(services, guildID, m.Author.ID); err != nil {
+				commandList.showError(channelID, err.Error())
+				return false
+			}
 		}
 
-		if err := services.plex.InviteFriend(params); err != nil {
-			if isVerbose {
-				fmt.Printf("invite() - inviteFriend failed: %v\n", err)
+		commandList.discord.ChannelMessageSend(channelID, "inviting user to our Plex Media Server")
+
+		usernameOrEmail := args[0]
+
+		if err := sendInvite(link, usernameOrEmail, settings); err != nil {
+			if !admin {
+				releaseQuota(services, guildID, m.Author.ID)
 			}
 
-			commandList.showError(channelID, fmt.Sprintf("invite could not be sent to %s", usernameOrEmail))
+			commandList.showError(channelID, err.Error())
 			return false
 		}
 
-		commandList.discord.ChannelMessageSend(channelID, fmt.Sprintf("invited %s to our Plex server", usernameOrEmail))
+		trackInvite(services, trackedInvite{
+			GuildID:         guildID,
+			ChannelID:       channelID,
+			InvitedBy:       m.Author.ID,
+			UsernameOrEmail: usernameOrEmail,
+			InvitedAt:       time.Now(),
+		})
+
+		err = linkAccount(services, linkedAccount{
+			GuildID:   guildID,
+			DiscordID: memberID,
+			PlexUser:  usernameOrEmail,
+			LinkedBy:  m.Author.ID,
+			LinkedAt:  time.Now(),
+		})
+
+		if err != nil {
+			fmt.Printf("invite() - could not link account: %v\n", err)
+		}
+
+		message := fmt.Sprintf("invited %s to our Plex server (%s)", usernameOrEmail, describeSharing(settings))
+
+		if trialLength > 0 {
+			trial := newTrial(guildID, channelID, memberID, usernameOrEmail, trialLength)
+
+			if err := services.data.update(func(data *dobbyData) {
+				data.Trials = append(data.Trials, trial)
+			}); err != nil {
+				fmt.Printf("invite() - could not save trial: %v\n", err)
+				commandList.showError(channelID, "`internal error - could not save when the invite expires, it will not expire`")
+			} else {
+				message += fmt.Sprintf("\naccess expires %s", formatUntil(trial.ExpiresAt))
+			}
+		}
+
+		commandList.discord.ChannelMessageSend(channelID, message)
 
 		return true
 	}
 }
-
-// search search media for on your Plex Media Server
-// func search(commandList d, services *clients) func(channelID string, args ...string) bool {
-// 	return func(channelID string, args ...string) bool {
-// 		commandList.discord.ChannelMessageSend(channelID, "inviting user to our Plex Media Server")
-
-// 		return true
-// 	}
-// }
-
-// func showLibrary(commandList d, services clients) func(channelID string, args ...string) {
-// 	return func(channelID string, args ...string) {
-// 		// command: library <movie|show> [missing, downloaded, or missing] <page-number>
-// 		// page number is optional -- w/o page number we'll show the first page of results
-// 		//
-// 		// examples:
-// 		// library movie
-// 		// library movie 3
-// 		// library movie missing
-// 		// library movie missing 3
-// 		// library movie downloaded 6
-
-// 		argCount := len(args)
-
-// 		if argCount < 1 {
-// 			commandList.showError(channelID, "need arg `movie|show`")
-// 			return
-// 		}
-
-// 		mediaType := args[0]
-
-// 		args = args[1:argCount]
-// 		argCount--
-
-// 		// args should be: "", "1" (page), "monitored" (a filter type), "monitored 2" (a filter type + page number)
-
-// 		page := "1"
-// 		pageSize := "40"
-
-// 		switch mediaType {
-// 		case "movie":
-// 			options := radarr.GetMovieOptions{
-// 				Page:     page,
-// 				PageSize: pageSize,
-// 				SortKey:  "sortTitle",
-// 				SortDir:  "asc",
-// 			}
-
-// 			if argCount > 0 {
-// 				// check for a page number
-// 				// if successful there's no filter
-// 				if _, err := strconv.Atoi(args[0]); err != nil {
-// 					// we could not convert so we most likely have a filter
-// 					filter := args[0]
-// 					filterValue := "true"
-
-// 					switch filter {
-// 					case "monitored":
-// 						filter = "monitored"
-// 					case "downloaded":
-// 						filter = "downloaded"
-// 					case "missing":
-// 						filter = "downloaded"
-// 						filterValue = "false"
-// 					case "released":
-// 						filter = "status"
-// 						filterValue = "released"
-// 					case "announced":
-// 						filter = "status"
-// 						filterValue = "announced"
-// 					case "cinemas":
-// 						filter = "status"
-// 						filterValue = "inCinemas"
-// 					default:
-// 						commandList.showError(channelID, fmt.Sprintf("unknown filter `%s` for command `library movie`", filter))
-// 						return
-// 					}
-
-// 					options.FilterKey = filter
-// 					options.FilterValue = filterValue
-// 					options.FilterType = "equal"
-
-// 					// check for page number
-// 					if argCount > 1 {
-// 						if _, err := strconv.Atoi(args[1]); err == nil {
-// 							page = args[1]
-// 							options.Page = page
-// 						}
-// 					}
-
-// 				} else {
-// 					// we converted the argument to a number
-// 					// so we have a page number
-// 					page = args[0]
-// 					options.Page = page
-// 				}
-// 			}
-
-// 			movies, err := services.radarr.GetMovies(options)
-
-// 			if err != nil {
-// 				output := fmt.Sprintf("fetch movies from radarr failed: %v", err)
-
-// 				commandList.showError(channelID, output)
-// 				logPrint(channelID, output)
-// 				return
-// 			}
-
-// 			movieCount := len(movies)
-// 			// the output preface has 35 chars
-// 			output := fmt.Sprintf("showing %d movies on page %s:\n\n",
-// 				movieCount, page)
-// 			titleLen := 0
-// 			yearLen := 0
-
-// 			// no movies but there is a page argument
-// 			if movieCount < 1 && page != "" {
-// 				output += "uh oh! try going back a page!"
-// 			} else if movieCount < 1 && page == "" {
-// 				output += "add some movies to your library! :smile:"
-// 			}
-
-// 			for _, movie := range movies {
-// 				yearStr := strconv.Itoa(movie.Year)
-
-// 				// '<title> (2000) - downloaded\n'
-// 				//  <-x-->|<------26 chars ---->|
-// 				//
-// 				// x == title; average char length is 14
-// 				// if not downloaded subtract 13 chars
-// 				// if downloaded total would be 82
-// 				// not downloaded total is 69 for each movie
-// 				//
-// 				// we can average ~50 movies before we need to go to the next page
-// 				output += movie.Title + " (" + yearStr + ") "
-
-// 				if movie.Downloaded {
-// 					output += " - `downloaded`"
-// 				}
-
-// 				output += "\n"
-
-// 				titleLen += len(movie.Title)
-// 				yearLen += len(yearStr)
-// 			}
-
-// 			if isVerbose {
-// 				// get the average movie + year length to determine how many movies we can show in discord
-// 				// without going over the 2000 char limit
-// 				fmt.Printf("movie count: %d\n", movieCount)
-// 				fmt.Printf("\ttotal title length: %d\n\ttotal year length: %d\n", titleLen, yearLen)
-
-// 				averageTitleLen := 0
-// 				averageYearLen := 0
-
-// 				if movieCount != 0 {
-// 					averageTitleLen = titleLen / movieCount
-// 					averageYearLen = yearLen / movieCount
-// 				}
-
-// 				fmt.Printf("\taverage title length: %d\n\taverage year length: %d\n", averageTitleLen, averageYearLen)
-// 				fmt.Printf("\ttotal message length: %d\n", len(output))
-// 			}
-
-// 			if _, err := commandList.discord.ChannelMessageSend(channelID, output); err != nil {
-// 				fmt.Printf("message sent to discord failed: %v\n", err)
-// 				commandList.discord.ChannelMessageSend(channelID, fmt.Sprintf("could not reply back: %v", err))
-// 			}
-// 		case "show":
-// 			output := "`library show` not implemented"
-// 			if _, err := commandList.discord.ChannelMessageSend(channelID, output); err != nil {
-// 				fmt.Printf("message sent to discord failed: %v\n", err)
-// 				commandList.discord.ChannelMessageSend(channelID, fmt.Sprintf("could not reply back: %v", err))
-// 			}
-// 		default:
-// 			output := "unknown command"
-
-// 			logPrint(channelID, output)
-// 		}
-// 	}
-// }
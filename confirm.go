@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// confirmTimeout is how long a user has to confirm an action
+const confirmTimeout = 60 * time.Second
+
+// pendingAction is an action waiting for the user who requested it to confirm
+type pendingAction struct {
+	description string
+	run         func()
+	expires     time.Time
+}
+
+var pendingActions = struct {
+	actions map[string]pendingAction
+	lock    sync.Mutex
+}{
+	actions: map[string]pendingAction{},
+}
+
+func pendingActionKey(m *discordgo.Message) string {
+	return m.ChannelID + ":" + m.Author.ID
+}
+
+// askConfirmation holds on to run until the author of m confirms with `confirm`
+//
+// a new request replaces any action the author had waiting in the channel
+func askConfirmation(commandList d, m *discordgo.Message, description string, run func()) {
+	pendingActions.lock.Lock()
+	pendingActions.actions[pendingActionKey(m)] = pendingAction{
+		description: description,
+		run:         run,
+		expires:     time.Now().Add(confirmTimeout),
+	}
+	pendingActions.lock.Unlock()
+
+	commandList.sendLongMessage(m.ChannelID, fmt.Sprintf("%s\nreply `confirm` within %d seconds to go ahead or `cancel` to stop",
+		description,
+		int(confirmTimeout.Seconds())))
+}
+
+// takePendingAction removes and returns the author's waiting action
+func takePendingAction(m *discordgo.Message) (pendingAction, bool) {
+	pendingActions.lock.Lock()
+	defer pendingActions.lock.Unlock()
+
+	key := pendingActionKey(m)
+	action, ok := pendingActions.actions[key]
+
+	delete(pendingActions.actions, key)
+
+	if ok && time.Now().After(action.expires) {
+		return action, false
+	}
+
+	return action, ok
+}
+
+// confirm runs the action the user has waiting
+func confirm(commandList d, services *clients) func(m *discordgo.Message, args ...string) bool {
+	return func(m *discordgo.Message, args ...string) bool {
+		action, ok := takePendingAction(m)
+
+		if !ok {
+			commandList.showError(m.ChannelID, "you have nothing to confirm")
+			return false
+		}
+
+		action.run()
+
+		return true
+	}
+}
+
+// cancel drops the action the user has waiting
+func cancel(commandList d, services *clients) func(m *discordgo.Message, args ...string) bool {
+	return func(m *discordgo.Message, args ...string) bool {
+		action, ok := takePendingAction(m)
+
+		if !ok {
+			commandList.showError(m.ChannelID, "you have nothing to cancel")
+			return false
+		}
+
+		commandList.discord.ChannelMessageSend(m.ChannelID, "cancelled: "+action.description)
+
+		return true
+	}
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/jrudio/go-plex-client"
+)
+
+const (
+	friendsPageSize = 10
+	// historyPageSize is how many plays are read from the play history at a time
+	historyPageSize = 1000
+	// lastStreamsLimit is how much recent play history is read for last stream times
+	lastStreamsLimit = 2000
+)
+
+// sharedServer is a plex user our server is shared with, or was invited to it
+type sharedServer struct {
+	ID         int    `xml:"id,attr"`
+	UserID     int    `xml:"userID,attr"`
+	Username   string `xml:"username,attr"`
+	Email      string `xml:"email,attr"`
+	AllowSync  string `xml:"allowSync,attr"`
+	InvitedAt  int64  `xml:"invitedAt,attr"`
+	AcceptedAt int64  `xml:"acceptedAt,attr"`
+	LastSeenAt int64  `xml:"lastSeenAt,attr"`
+	Section    []struct {
+		ID     int    `xml:"id,attr"`
+		Title  string `xml:"title,attr"`
+		Shared string `xml:"shared,attr"`
+	} `xml:"Section"`
+}
+
+type sharedServersResponse struct {
+	SharedServer []sharedServer `xml:"SharedServer"`
+}
+
+// isPending is true until the invited user accepts
+func (s sharedServer) isPending() bool {
+	return s.AcceptedAt == 0
+}
+
+// name is the username or, for pending invites to an email, the email
+func (s sharedServer) name() string {
+	if s.Username != "" {
+		return s.Username
+	}
+
+	return s.Email
+}
+
+// libraries returns the titles of the libraries shared with the user
+func (s sharedServer) libraries() []string {
+	libraries := []string{}
+
+	for _, section := range s.Section {
+		if section.Shared == "1" {
+			libraries = append(libraries, section.Title)
+		}
+	}
+
+	return libraries
+}
+
+// getSharedServers lists everyone our server is shared with, including pending invites
+func getSharedServers(plexClient *plex.Plex, machineID string) ([]sharedServer, error) {
+	resp, err := plexTVRequest(plexClient, "GET", plexTVURL+"/api/servers/"+machineID+"/shared_servers", nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(resp.Status)
+	}
+
+	var result sharedServersResponse
+
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	return result.SharedServer, nil
+}
+
+// playHistory is the response of /status/sessions/history/all
+type playHistory struct {
+	MediaContainer struct {
+		TotalSize int `json:"totalSize"`
+		Metadata  []struct {
+			AccountID int   `json:"accountID"`
+			ViewedAt  int64 `json:"viewedAt"`
+		} `json:"Metadata"`
+	} `json:"MediaContainer"`
+}
+
+// getLastStreams returns when each plex account last played something on the server
+// keyed by plex account id
+//
+// only recent history is read so accounts that have not streamed in a long time may be missing
+func getLastStreams(plexClient *plex.Plex) (map[int]time.Time, error) {
+	return readStreams(plexClient, "", lastStreamsLimit)
+}
+
+// getStreamsSince is getLastStreams for every play after since
+func getStreamsSince(plexClient *plex.Plex, since time.Time) (map[int]time.Time, error) {
+	return readStreams(plexClient, fmt.Sprintf("&viewedAt>=%d", since.Unix()), 0)
+}
+
+// readStreams pages through the play history matching filter, newest first.
+// a limit above 0 stops after that many plays
+func readStreams(plexClient *plex.Plex, filter string, limit int) (map[int]time.Time, error) {
+	lastStreams := map[int]time.Time{}
+
+	for start := 0; limit == 0 || start < limit; {
+		var history playHistory
+
+		query := fmt.Sprintf("/status/sessions/history/all?sort=viewedAt:desc&X-Plex-Container-Start=%d&X-Plex-Container-Size=%d%s",
+			start, historyPageSize, filter)
+
+		if err := pmsGet(plexClient, query, &history); err != nil {
+			return nil, err
+		}
+
+		plays := history.MediaContainer.Metadata
+
+		for _, item := range plays {
+			viewedAt := time.Unix(item.ViewedAt, 0)
+
+			if viewedAt.After(lastStreams[item.AccountID]) {
+				lastStreams[item.AccountID] = viewedAt
+			}
+		}
+
+		start += len(plays)
+
+		// servers that don't send totalSize end with a short page
+		done := len(plays) < historyPageSize
+
+		if total := history.MediaContainer.TotalSize; total > 0 {
+			done = start >= total
+		}
+
+		if len(plays) == 0 || done {
+			break
+		}
+	}
+
+	return lastStreams, nil
+}
+
+// formatAge turns a time into something like "3d ago"
+func formatAge(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+
+	age := time.Since(t)
+
+	switch {
+	case age < time.Hour:
+		return fmt.Sprintf("%dm ago", int(age.Minutes()))
+	case age < 24*time.Hour:
+		return fmt.Sprintf("%dh ago", int(age.Hours()))
+	default:
+		return fmt.Sprintf("%dd ago", int(age.Hours()/24))
+	}
+}
+
+// friends lists the plex users that have access to the server
+//
+// command: friends [page] [--pending] [--accepted] [--library Movies] [--search name]
+func friends(commandList d, services *clients) func(m *discordgo.Message, args ...string) bool {
+	return func(m *discordgo.Message, args ...string) bool {
+		channelID := m.ChannelID
+		guildID := commandList.getGuildID(channelID)
+		link := services.getPlexLink(guildID)
+
+		if !link.authorized() {
+			commandList.showError(channelID, "dobby is not linked to a plex server -- run `link`")
+			return false
+		}
+
+		args, options, err := parseOptions(args, optionList{"pending": false, "accepted": false, "library": true, "search": true})
+
+		if err != nil {
+			commandList.showError(channelID, err.Error())
+			return false
+		}
+
+		page := 1
+
+		if len(args) > 0 {
+			var err error
+
+			if page, err = strconv.Atoi(args[0]); err != nil || page < 1 {
+				commandList.showError(channelID, fmt.Sprintf("`%s` is not a page number", args[0]))
+				return false
+			}
+		}
+
+		machineID, err := link.client().GetMachineID()
+
+		if err != nil {
+			fmt.Printf("friends() - could not fetch machine id: %v\n", err)
+			commandList.showError(channelID, "dobby error - could not get machine id from plex server")
+			return false
+		}
+
+		shared, err := getSharedServers(link.client(), machineID)
+
+		if err != nil {
+			fmt.Printf("friends() - could not fetch shared users: %v\n", err)
+			commandList.showError(channelID, "could not fetch the users of your plex server")
+			return false
+		}
+
+		lastStreams, err := getLastStreams(link.client())
+
+		if err != nil {
+			// not fatal -- we just can't show last stream times
+			fmt.Printf("friends() - could not fetch play history: %v\n", err)
+		}
+
+		filtered := []sharedServer{}
+
+		for _, user := range shared {
+			if _, ok := options["pending"]; ok && !user.isPending() {
+				continue
+			}
+
+			if _, ok := options["accepted"]; ok && user.isPending() {
+				continue
+			}
+
+			if search, ok := options["search"]; ok &&
+				!strings.Contains(strings.ToLower(user.Username), strings.ToLower(search)) &&
+				!strings.Contains(strings.ToLower(user.Email), strings.ToLower(search)) {
+				continue
+			}
+
+			if library, ok := options["library"]; ok && !containsFold(user.libraries(), library) {
+				continue
+			}
+
+			filtered = append(filtered, user)
+		}
+
+		sort.Slice(filtered, func(i, j int) bool {
+			return strings.ToLower(filtered[i].name()) < strings.ToLower(filtered[j].name())
+		})
+
+		pageCount := (len(filtered) + friendsPageSize - 1) / friendsPageSize
+
+		if len(filtered) == 0 {
+			commandList.discord.ChannelMessageSend(channelID, "no users found")
+			return true
+		}
+
+		if page > pageCount {
+			commandList.showError(channelID, fmt.Sprintf("there are only %d pages", pageCount))
+			return false
+		}
+
+		start := (page - 1) * friendsPageSize
+		end := start + friendsPageSize
+
+		if end > len(filtered) {
+			end = len(filtered)
+		}
+
+		message := fmt.Sprintf("showing %d of %d users (page %d/%d):\n\n", end-start, len(filtered), page, pageCount)
+
+		for _, user := range filtered[start:end] {
+			message += "**" + user.name() + "**"
+
+			if user.isPending() {
+				message += " `pending`"
+			}
+
+			if account, ok := findAccountByPlex(services, guildID, user); ok {
+				message += " <@" + account.DiscordID + ">"
+			}
+
+			sharedLibraries := user.libraries()
+			libraries := strings.Join(sharedLibraries, ", ")
+
+			switch {
+			case len(sharedLibraries) == 0:
+				libraries = "none"
+			case len(sharedLibraries) == len(user.Section):
+				libraries = "all"
+			}
+
+			lastSeen := time.Time{}
+
+			if user.LastSeenAt > 0 {
+				lastSeen = time.Unix(user.LastSeenAt, 0)
+			}
+
+			message += fmt.Sprintf("\n  libraries: %s\n  last seen: %s - last stream: %s\n",
+				libraries,
+				formatAge(lastSeen),
+				formatAge(lastStreams[user.UserID]))
+		}
+
+		if page < pageCount {
+			message += fmt.Sprintf("\nuse `friends %d` for the next page", page+1)
+		}
+
+		if err := commandList.sendLongMessage(channelID, message); err != nil {
+			fmt.Printf("friends() - message sent to discord failed: %v\n", err)
+		}
+
+		return true
+	}
+}
+
+// containsFold reports whether list contains s, ignoring case
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+
+	return false
+}
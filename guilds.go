@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// copyGuildCredentials copies guild credentials so a config can be changed
+// without touching the map other goroutines are reading
+func copyGuildCredentials(guilds map[string]plexCredentials) map[string]plexCredentials {
+	guildsCopy := make(map[string]plexCredentials, len(guilds))
+
+	for guildID, credentials := range guilds {
+		guildsCopy[guildID] = credentials
+	}
+
+	return guildsCopy
+}
+
+// updatePlexCredentials changes the in-memory credentials of link so they match what we saved
+// to secrets.toml and a reload does not undo it
+func (c *clients) updatePlexCredentials(link *plexLink, update func(credentials *plexCredentials)) {
+	config := c.getConfig()
+
+	if link.guildID == "" {
+		update(&config.Plex)
+	} else {
+		config.Guilds = copyGuildCredentials(config.Guilds)
+		guildCredentials := config.Guilds[link.guildID]
+		update(&guildCredentials)
+		config.Guilds[link.guildID] = guildCredentials
+	}
+
+	c.setConfig(config)
+}
+
+// selectServer lists the plex servers available to the guild's plex account or picks one
+//
+// command: server [number|name]
+func selectServer(commandList d, services *clients) func(m *discordgo.Message, args ...string) bool {
+	return func(m *discordgo.Message, args ...string) bool {
+		channelID := m.ChannelID
+		link := services.getPlexLink(commandList.getGuildID(channelID))
+
+		if link.client().Token == "" {
+			commandList.showError(channelID, "dobby is not linked to a plex account yet -- run `invite` to get a plex PIN")
+			return false
+		}
+
+		serverInfo, err := link.client().GetServersInfo()
+
+		if err != nil {
+			fmt.Printf("selectServer() - failed to fetch servers: %v\n", err)
+			commandList.showError(channelID, "could not fetch your plex servers")
+			return false
+		}
+
+		if len(args) < 1 {
+			message := "Plex servers:\n"
+
+			for i, server := range serverInfo.Server {
+				message += fmt.Sprintf("`%d` %s", i+1, server.Name)
+
+				if server.Scheme+"://"+server.Address+":"+server.Port == link.client().URL {
+					message += " (selected)"
+				}
+
+				message += "\n"
+			}
+
+			message += "use `server <number|name>` to pick one"
+
+			commandList.discord.ChannelMessageSend(channelID, message)
+
+			return true
+		}
+
+		selection := strings.Join(args, " ")
+		index, err := strconv.Atoi(selection)
+
+		if err != nil {
+			index = 0
+
+			for i, server := range serverInfo.Server {
+				if strings.EqualFold(server.Name, selection) {
+					index = i + 1
+					break
+				}
+			}
+		}
+
+		if index < 1 || index > len(serverInfo.Server) {
+			commandList.showError(channelID, fmt.Sprintf("unknown server `%s`", selection))
+			return false
+		}
+
+		server := serverInfo.Server[index-1]
+		host := server.Scheme + "://" + server.Address + ":" + server.Port
+
+		if err := link.authorize(host); err != nil {
+			fmt.Printf("selectServer() - %v\n", err)
+			commandList.showError(channelID, "could not connect to "+server.Name)
+			return false
+		}
+
+		if err := updateCredentials(secretsFilepath, credentialUpdate{table: link.credentialsTable(), key: "host", value: host}); err != nil {
+			fmt.Printf("selectServer() - updateCredentials failed: %v\n", err)
+			commandList.showError(channelID, "`internal error - could not save plex server`")
+			return false
+		}
+
+		services.updatePlexCredentials(link, func(credentials *plexCredentials) {
+			credentials.Host = host
+		})
+
+		commandList.discord.ChannelMessageSend(channelID, "dobby will now use "+server.Name)
+
+		return true
+	}
+}
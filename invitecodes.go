@@ -0,0 +1,390 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	inviteCodeLength = 8
+	// inviteCodeAlphabet leaves out characters that are easy to mix up
+	inviteCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+)
+
+// inviteCode lets members invite someone to the plex server without an admin
+type inviteCode struct {
+	Code      string    `toml:"code"`
+	GuildID   string    `toml:"guildID"`
+	CreatedBy string    `toml:"createdBy"`
+	CreatedAt time.Time `toml:"createdAt"`
+	// ExpiresAt is zero for codes that do not expire
+	ExpiresAt   time.Time    `toml:"expiresAt"`
+	MaxUses     int          `toml:"maxUses"`
+	Libraries   []string     `toml:"libraries"`
+	Redemptions []redemption `toml:"redemptions"`
+}
+
+// redemption is someone invited with an invite code
+type redemption struct {
+	// InvitedBy is the member that redeemed the code, not the person they invited
+	InvitedBy       string    `toml:"invitedBy"`
+	UsernameOrEmail string    `toml:"usernameOrEmail"`
+	RedeemedAt      time.Time `toml:"redeemedAt"`
+}
+
+// usable explains why a code can not be redeemed, or is empty when it can
+func (c inviteCode) usable() string {
+	if !c.ExpiresAt.IsZero() && time.Now().After(c.ExpiresAt) {
+		return "this invite code has expired"
+	}
+
+	if len(c.Redemptions) >= c.MaxUses {
+		return "this invite code has been used up"
+	}
+
+	return ""
+}
+
+// newInviteCodeString returns a random code like 7KQ2MZ4P
+func newInviteCodeString() (string, error) {
+	code := make([]byte, inviteCodeLength)
+	max := big.NewInt(int64(len(inviteCodeAlphabet)))
+
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+
+		if err != nil {
+			return "", err
+		}
+
+		code[i] = inviteCodeAlphabet[n.Int64()]
+	}
+
+	return string(code), nil
+}
+
+// findInviteCode looks up a code of a guild, ignoring case
+func findInviteCode(services *clients, guildID, code string) (inviteCode, bool) {
+	var found inviteCode
+	ok := false
+
+	services.data.view(func(data dobbyData) {
+		for _, c := range data.InviteCodes {
+			if c.GuildID == guildID && strings.EqualFold(c.Code, code) {
+				found = c
+				ok = true
+				return
+			}
+		}
+	})
+
+	return found, ok
+}
+
+// inviteCodes creates, lists and revokes invite codes
+//
+// command: invite-code create [--uses 1] [--expires 3d] [--libraries Movies] | invite-code list | invite-code revoke <code>
+func inviteCodes(commandList d, services *clients) func(m *discordgo.Message, args ...string) bool {
+	return func(m *discordgo.Message, args ...string) bool {
+		channelID := m.ChannelID
+		guildID := commandList.getGuildID(channelID)
+
+		args, options, err := parseOptions(args, optionList{"uses": true, "expires": true, "libraries": true})
+
+		if err != nil {
+			commandList.showError(channelID, err.Error())
+			return false
+		}
+
+		if len(args) < 1 {
+			commandList.showError(channelID, "usage: `invite-code create [--uses 1] [--expires 3d] [--libraries Movies]`, `invite-code list` or `invite-code revoke <code>`")
+			return false
+		}
+
+		switch args[0] {
+		case "create":
+			code := inviteCode{
+				GuildID:   guildID,
+				CreatedBy: m.Author.ID,
+				CreatedAt: time.Now(),
+				MaxUses:   1,
+			}
+
+			if uses, ok := options["uses"]; ok {
+				maxUses, err := strconv.Atoi(uses)
+
+				if err != nil || maxUses < 1 {
+					commandList.showError(channelID, fmt.Sprintf("`--uses %s` should be a number above 0", uses))
+					return false
+				}
+
+				code.MaxUses = maxUses
+			}
+
+			if expires, ok := options["expires"]; ok {
+				length, err := parseDuration(expires)
+
+				if err != nil {
+					commandList.showError(channelID, err.Error())
+					return false
+				}
+
+				code.ExpiresAt = time.Now().Add(length)
+			}
+
+			if libraries, ok := options["libraries"]; ok {
+				code.Libraries = splitLibraries(libraries)
+
+				// catch typos now rather than when someone redeems the code
+				if link := services.getPlexLink(guildID); link.authorized() {
+					if machineID, err := link.client().GetMachineID(); err == nil {
+						if _, err := resolveLibraries(link.client(), machineID, code.Libraries); err != nil {
+							commandList.showError(channelID, err.Error())
+							return false
+						}
+					}
+				}
+			}
+
+			var err error
+
+			if code.Code, err = newInviteCodeString(); err != nil {
+				fmt.Printf("inviteCodes() - could not generate code: %v\n", err)
+				commandList.showError(channelID, "`internal error - could not generate an invite code`")
+				return false
+			}
+
+			if err := services.data.update(func(data *dobbyData) {
+				data.InviteCodes = append(data.InviteCodes, code)
+			}); err != nil {
+				fmt.Printf("inviteCodes() - could not save codes: %v\n", err)
+				commandList.showError(channelID, "`internal error - could not save the invite code`")
+				return false
+			}
+
+			audit(guildID, m.Author.ID, "invite-code-create", fmt.Sprintf("code=%s uses=%d libraries=%s", code.Code, code.MaxUses, strings.Join(code.Libraries, ",")))
+
+			commandList.discord.ChannelMessageSend(channelID, fmt.Sprintf("invite code `%s` -- %s\nredeem it with `redeem %s <email>`",
+				code.Code, describeInviteCode(code), code.Code))
+
+			return true
+
+		case "list":
+			message := ""
+
+			services.data.view(func(data dobbyData) {
+				for _, code := range data.InviteCodes {
+					if code.GuildID != guildID {
+						continue
+					}
+
+					message += fmt.Sprintf("`%s` -- %s", code.Code, describeInviteCode(code))
+
+					if reason := code.usable(); reason != "" {
+						message += " (" + strings.TrimPrefix(reason, "this invite code has ") + ")"
+					}
+
+					message += "\n"
+
+					for _, redeemed := range code.Redemptions {
+						message += fmt.Sprintf("  <@%s> invited `%s` %s\n", redeemed.InvitedBy, redeemed.UsernameOrEmail, formatAge(redeemed.RedeemedAt))
+					}
+				}
+			})
+
+			if message == "" {
+				message = "there are no invite codes -- `invite-code create`"
+			} else {
+				message = "Invite codes:\n" + message
+			}
+
+			if err := commandList.sendLongMessage(channelID, message); err != nil {
+				fmt.Printf("inviteCodes() - message sent to discord failed: %v\n", err)
+			}
+
+			return true
+
+		case "revoke":
+			if len(args) < 2 {
+				commandList.showError(channelID, "a code is required")
+				return false
+			}
+
+			if _, ok := findInviteCode(services, guildID, args[1]); !ok {
+				commandList.showError(channelID, fmt.Sprintf("there is no invite code `%s`", args[1]))
+				return false
+			}
+
+			err := services.data.update(func(data *dobbyData) {
+				codes := data.InviteCodes[:0]
+
+				for _, code := range data.InviteCodes {
+					if code.GuildID == guildID && strings.EqualFold(code.Code, args[1]) {
+						continue
+					}
+
+					codes = append(codes, code)
+				}
+
+				data.InviteCodes = codes
+			})
+
+			if err != nil {
+				fmt.Printf("inviteCodes() - could not save codes: %v\n", err)
+				commandList.showError(channelID, "`internal error - could not save invite codes`")
+				return false
+			}
+
+			audit(guildID, m.Author.ID, "invite-code-revoke", "code="+strings.ToUpper(args[1]))
+
+			commandList.discord.ChannelMessageSend(channelID, fmt.Sprintf("invite code `%s` can no longer be redeemed", strings.ToUpper(args[1])))
+
+			return true
+		}
+
+		commandList.showError(channelID, fmt.Sprintf("unknown subcommand `%s` for command `invite-code`", args[0]))
+
+		return false
+	}
+}
+
+// describeInviteCode summarizes the uses, expiry and libraries of a code
+func describeInviteCode(code inviteCode) string {
+	description := fmt.Sprintf("%d/%d uses", len(code.Redemptions), code.MaxUses)
+
+	if !code.ExpiresAt.IsZero() {
+		description += ", expires " + formatUntil(code.ExpiresAt)
+	}
+
+	return description + ", " + describeSharing(sharingSettings{libraries: code.Libraries})
+}
+
+// releaseRedemption gives back a use of a code that did not lead to an invite
+func releaseRedemption(services *clients, guildID, code, invitedBy, usernameOrEmail string) {
+	err := services.data.update(func(data *dobbyData) {
+		for i := range data.InviteCodes {
+			if data.InviteCodes[i].GuildID != guildID || data.InviteCodes[i].Code != code {
+				continue
+			}
+
+			redemptions := data.InviteCodes[i].Redemptions[:0]
+
+			for _, redeemed := range data.InviteCodes[i].Redemptions {
+				if redeemed.InvitedBy == invitedBy && redeemed.UsernameOrEmail == usernameOrEmail {
+					continue
+				}
+
+				redemptions = append(redemptions, redeemed)
+			}
+
+			data.InviteCodes[i].Redemptions = redemptions
+		}
+	})
+
+	if err != nil {
+		fmt.Printf("releaseRedemption() - could not save codes: %v\n", err)
+	}
+}
+
+// redeem invites someone to the plex server with an invite code
+//
+// command: redeem <code> <email|username>
+func redeem(commandList d, services *clients) func(m *discordgo.Message, args ...string) bool {
+	return func(m *discordgo.Message, args ...string) bool {
+		channelID := m.ChannelID
+		guildID := commandList.getGuildID(channelID)
+		link := services.getPlexLink(guildID)
+
+		if len(args) < 2 {
+			commandList.showError(channelID, "usage: `redeem <code> <email|username>`")
+			return false
+		}
+
+		if !link.authorized() {
+			commandList.showError(channelID, "dobby is not linked to a plex server -- ask an admin to run `link`")
+			return false
+		}
+
+		code, ok := findInviteCode(services, guildID, args[0])
+
+		if !ok {
+			commandList.showError(channelID, fmt.Sprintf("`%s` is not an invite code", args[0]))
+			return false
+		}
+
+		if reason := code.usable(); reason != "" {
+			commandList.showError(channelID, reason)
+			return false
+		}
+
+		admin := isAdmin(commandList, services, m.Author.ID, channelID)
+
+		usernameOrEmail := args[1]
+
+		// claim a use before inviting so two members can't race for the last one
+		claimed := false
+
+		err := services.data.update(func(data *dobbyData) {
+			for i := range data.InviteCodes {
+				if data.InviteCodes[i].GuildID != guildID || data.InviteCodes[i].Code != code.Code || data.InviteCodes[i].usable() != "" {
+					continue
+				}
+
+				data.InviteCodes[i].Redemptions = append(data.InviteCodes[i].Redemptions, redemption{
+					InvitedBy:       m.Author.ID,
+					UsernameOrEmail: usernameOrEmail,
+					RedeemedAt:      time.Now(),
+				})
+				claimed = true
+			}
+		})
+
+		if err != nil || !claimed {
+			if err != nil {
+				fmt.Printf("redeem() - could not save codes: %v\n", err)
+			}
+
+			commandList.showError(channelID, "this invite code can not be redeemed anymore")
+			return false
+		}
+
+		if !admin {
+			if err := claimQuota(services, guildID, m.Author.ID); err != nil {
+				releaseRedemption(services, guildID, code.Code, m.Author.ID, usernameOrEmail)
+				commandList.showError(channelID, err.Error())
+				return false
+			}
+		}
+
+		if err := sendInvite(link, usernameOrEmail, sharingSettings{libraries: code.Libraries}); err != nil {
+			releaseRedemption(services, guildID, code.Code, m.Author.ID, usernameOrEmail)
+
+			if !admin {
+				releaseQuota(services, guildID, m.Author.ID)
+			}
+
+			commandList.showError(channelID, err.Error())
+			return false
+		}
+
+		trackInvite(services, trackedInvite{
+			GuildID:         guildID,
+			ChannelID:       channelID,
+			InvitedBy:       m.Author.ID,
+			UsernameOrEmail: usernameOrEmail,
+			InvitedAt:       time.Now(),
+		})
+
+		audit(guildID, m.Author.ID, "redeem", fmt.Sprintf("code=%s user=%s", code.Code, usernameOrEmail))
+
+		commandList.discord.ChannelMessageSend(channelID, fmt.Sprintf("invited %s to our Plex server (%s)", usernameOrEmail, describeSharing(sharingSettings{libraries: code.Libraries})))
+
+		return true
+	}
+}
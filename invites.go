@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// inviteCheckInterval is how often we look for invites that were accepted
+const inviteCheckInterval = 5 * time.Minute
+
+// trackedInvite is an invite sent through dobby that we watch until it gets accepted
+type trackedInvite struct {
+	GuildID         string    `toml:"guildID"`
+	ChannelID       string    `toml:"channelID"`
+	InvitedBy       string    `toml:"invitedBy"`
+	UsernameOrEmail string    `toml:"usernameOrEmail"`
+	InvitedAt       time.Time `toml:"invitedAt"`
+}
+
+// trackInvite remembers an invite so we can announce when it gets accepted
+func trackInvite(services *clients, invite trackedInvite) {
+	err := services.data.update(func(data *dobbyData) {
+		data.Invites = append(data.Invites, invite)
+	})
+
+	if err != nil {
+		fmt.Printf("trackInvite() - could not save invite: %v\n", err)
+	}
+}
+
+// untrackInvite stops watching invites to usernameOrEmail in a guild
+//
+// invites saved without a guild were sent to the default plex server and match any guild
+func untrackInvite(services *clients, guildID, usernameOrEmail string) {
+	err := services.data.update(func(data *dobbyData) {
+		invites := data.Invites[:0]
+
+		for _, invite := range data.Invites {
+			if (invite.GuildID == guildID || invite.GuildID == "") && strings.EqualFold(invite.UsernameOrEmail, usernameOrEmail) {
+				continue
+			}
+
+			invites = append(invites, invite)
+		}
+
+		data.Invites = invites
+	})
+
+	if err != nil {
+		fmt.Printf("untrackInvite() - could not save invites: %v\n", err)
+	}
+}
+
+// watchInvites periodically checks tracked invites and announces the accepted ones
+// in the channel the invite was sent from
+func watchInvites(commandList d, services *clients) {
+	for {
+		time.Sleep(inviteCheckInterval)
+
+		checkInvites(commandList, services)
+	}
+}
+
+func checkInvites(commandList d, services *clients) {
+	var invites []trackedInvite
+
+	services.data.view(func(data dobbyData) {
+		invites = append(invites, data.Invites...)
+	})
+
+	// only ask plex once per guild
+	sharedByGuild := map[string][]sharedServer{}
+
+	for _, invite := range invites {
+		shared, ok := sharedByGuild[invite.GuildID]
+
+		if !ok {
+			link := services.getPlexLink(invite.GuildID)
+
+			if !link.authorized() {
+				continue
+			}
+
+			machineID, err := link.client().GetMachineID()
+
+			if err != nil {
+				fmt.Printf("checkInvites() - could not fetch machine id: %v\n", err)
+				continue
+			}
+
+			if shared, err = getSharedServers(link.client(), machineID); err != nil {
+				fmt.Printf("checkInvites() - could not fetch shared users: %v\n", err)
+				continue
+			}
+
+			sharedByGuild[invite.GuildID] = shared
+		}
+
+		user, ok := findSharedServer(shared, invite.UsernameOrEmail)
+
+		if ok && user.isPending() {
+			continue
+		}
+
+		// accepted, or the invite was declined or cancelled outside of dobby
+		untrackInvite(services, invite.GuildID, invite.UsernameOrEmail)
+
+		if ok {
+			commandList.discord.ChannelMessageSend(invite.ChannelID, fmt.Sprintf("%s accepted their invite to our Plex server :tada:", user.name()))
+		}
+	}
+}
+
+// invites lists or cancels pending invites
+//
+// command: invites pending | invites cancel <username|email|@member>
+func invites(commandList d, services *clients) func(m *discordgo.Message, args ...string) bool {
+	return func(m *discordgo.Message, args ...string) bool {
+		channelID := m.ChannelID
+		guildID := commandList.getGuildID(channelID)
+		link := services.getPlexLink(guildID)
+
+		if len(args) < 1 || (args[0] != "pending" && args[0] != "cancel") {
+			commandList.showError(channelID, "usage: `invites pending` or `invites cancel <username|email|@member>`")
+			return false
+		}
+
+		if !link.authorized() {
+			commandList.showError(channelID, "dobby is not linked to a plex server -- run `link`")
+			return false
+		}
+
+		machineID, err := link.client().GetMachineID()
+
+		if err != nil {
+			fmt.Printf("invites() - could not fetch machine id: %v\n", err)
+			commandList.showError(channelID, "dobby error - could not get machine id from plex server")
+			return false
+		}
+
+		shared, err := getSharedServers(link.client(), machineID)
+
+		if err != nil {
+			fmt.Printf("invites() - could not fetch shared users: %v\n", err)
+			commandList.showError(channelID, "could not fetch the users of your plex server")
+			return false
+		}
+
+		if args[0] == "pending" {
+			pending := []sharedServer{}
+
+			for _, user := range shared {
+				if user.isPending() {
+					pending = append(pending, user)
+				}
+			}
+
+			if len(pending) == 0 {
+				commandList.discord.ChannelMessageSend(channelID, "there are no pending invites")
+				return true
+			}
+
+			// oldest first
+			sort.Slice(pending, func(i, j int) bool {
+				return pending[i].InvitedAt < pending[j].InvitedAt
+			})
+
+			message := fmt.Sprintf("%d pending invites:\n", len(pending))
+
+			for _, user := range pending {
+				message += fmt.Sprintf("`%s` - invited %s\n", user.name(), formatAge(time.Unix(user.InvitedAt, 0)))
+			}
+
+			if err := commandList.sendLongMessage(channelID, message); err != nil {
+				fmt.Printf("invites() - message sent to discord failed: %v\n", err)
+			}
+
+			return true
+		}
+
+		if len(args) < 2 {
+			commandList.showError(channelID, "a username, an email or a @mention is required")
+			return false
+		}
+
+		target, _, err := resolvePlexUser(services, guildID, args[1])
+
+		if err != nil {
+			commandList.showError(channelID, err.Error())
+			return false
+		}
+
+		user, ok := findSharedServer(shared, target)
+
+		if !ok || !user.isPending() {
+			commandList.showError(channelID, fmt.Sprintf("there is no pending invite for `%s`", target))
+			return false
+		}
+
+		if err := removeSharedServer(link.client(), machineID, user); err != nil {
+			fmt.Printf("invites() - could not cancel invite: %v\n", err)
+			commandList.showError(channelID, fmt.Sprintf("could not cancel the invite to %s", user.name()))
+			return false
+		}
+
+		untrackInvite(services, guildID, target)
+
+		audit(guildID, m.Author.ID, "cancel-invite", fmt.Sprintf("user=%s email=%s", user.Username, user.Email))
+
+		commandList.discord.ChannelMessageSend(channelID, fmt.Sprintf("cancelled the invite to %s", user.name()))
+
+		return true
+	}
+}
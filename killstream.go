@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// defaultKillReason is shown to viewers when an admin doesn't give a reason
+const defaultKillReason = "An admin has stopped this stream"
+
+// plexUsername turns an email into the username of that user on our plex server
+// because sessions only know usernames. usernames are returned as they are
+func plexUsername(link *plexLink, usernameOrEmail string) (string, error) {
+	if !strings.Contains(usernameOrEmail, "@") {
+		return usernameOrEmail, nil
+	}
+
+	machineID, err := link.client().GetMachineID()
+
+	if err != nil {
+		return "", fmt.Errorf("could not get machine id from plex server: %v", err)
+	}
+
+	shared, err := getSharedServers(link.client(), machineID)
+
+	if err != nil {
+		return "", fmt.Errorf("could not fetch the users of your plex server: %v", err)
+	}
+
+	user, ok := findSharedServer(shared, usernameOrEmail)
+
+	if !ok || user.Username == "" {
+		return "", fmt.Errorf("`%s` has not accepted an invite to our plex server -- use their plex username", usernameOrEmail)
+	}
+
+	return user.Username, nil
+}
+
+// findSessions picks the sessions a command argument points at: a session key like #12,
+// or every session of a plex user, @member or email
+func findSessions(services *clients, link *plexLink, guildID string, sessions []playSession, arg string) ([]playSession, error) {
+	key := strings.TrimPrefix(arg, "#")
+
+	for _, s := range sessions {
+		if s.SessionKey == key {
+			return []playSession{s}, nil
+		}
+	}
+
+	target, _, err := resolvePlexUser(services, guildID, arg)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if target, err = plexUsername(link, target); err != nil {
+		return nil, err
+	}
+
+	found := []playSession{}
+
+	for _, s := range sessions {
+		if strings.EqualFold(s.User.Title, target) {
+			found = append(found, s)
+		}
+	}
+
+	if len(found) == 0 {
+		return nil, fmt.Errorf("nothing is playing for `%s` -- use `nowplaying` to see session numbers", arg)
+	}
+
+	return found, nil
+}
+
+// terminateSessions stops sessions, showing reason to the viewer, and returns those that could not be stopped
+func terminateSessions(link *plexLink, guildID, actorID, reason string, sessions []playSession) []playSession {
+	failed := []playSession{}
+
+	for _, s := range sessions {
+		if err := link.client().TerminateSession(s.Session.ID, reason); err != nil {
+			fmt.Printf("terminateSessions() - could not stop session %s of %s: %v\n", s.SessionKey, s.User.Title, err)
+			failed = append(failed, s)
+			continue
+		}
+
+		audit(guildID, actorID, "kill-stream", fmt.Sprintf("user=%s session=%s title=%q reason=%q", s.User.Title, s.SessionKey, s.name(), reason))
+	}
+
+	return failed
+}
+
+// killStream stops playback sessions on the plex server
+//
+// command: kill-stream <#session|username|email|@member> [reason]
+func killStream(commandList d, services *clients) func(m *discordgo.Message, args ...string) bool {
+	return func(m *discordgo.Message, args ...string) bool {
+		channelID := m.ChannelID
+		guildID := commandList.getGuildID(channelID)
+		link := services.getPlexLink(guildID)
+
+		if !link.authorized() {
+			commandList.showError(channelID, "dobby is not linked to a plex server -- run `link`")
+			return false
+		}
+
+		if len(args) < 1 {
+			commandList.showError(channelID, "usage: `kill-stream <#session|username|email|@member> [reason]`")
+			return false
+		}
+
+		reason := strings.Join(args[1:], " ")
+
+		if reason == "" {
+			reason = defaultKillReason
+		}
+
+		sessions, err := getSessions(link.client())
+
+		if err != nil {
+			fmt.Printf("killStream() - could not fetch sessions: %v\n", err)
+			commandList.showError(channelID, "could not fetch what is playing on your plex server")
+			return false
+		}
+
+		targets, err := findSessions(services, link, guildID, sessions, args[0])
+
+		if err != nil {
+			commandList.showError(channelID, err.Error())
+			return false
+		}
+
+		kill := func() {
+			failed := terminateSessions(link, guildID, m.Author.ID, reason, targets)
+
+			if len(failed) == len(targets) {
+				commandList.showError(channelID, "could not stop the stream -- stopping streams needs plex pass on the server owner's account, check the log for details")
+				return
+			}
+
+			message := fmt.Sprintf("stopped %d stream(s) of %s", len(targets)-len(failed), targets[0].User.Title)
+
+			if len(targets) == 1 {
+				message = fmt.Sprintf("stopped %s for %s", targets[0].name(), targets[0].User.Title)
+			}
+
+			if len(failed) > 0 {
+				message += fmt.Sprintf("\ncould not stop %d of them", len(failed))
+			}
+
+			commandList.discord.ChannelMessageSend(channelID, message)
+		}
+
+		if len(targets) == 1 {
+			kill()
+			return true
+		}
+
+		lines := make([]string, len(targets))
+
+		for i, s := range targets {
+			lines[i] = describeSession(services, guildID, s)
+		}
+
+		askConfirmation(commandList, m, fmt.Sprintf("stop these %d streams?\n%s", len(targets), strings.Join(lines, "\n")), kill)
+
+		return true
+	}
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/jrudio/go-plex-client"
+)
+
+const (
+	libraryPageSize = 20
+	// librarySizeTTL is how long the size of a library's files is remembered
+	librarySizeTTL = 6 * time.Hour
+	// librarySizePageSize is how many files are read at a time when adding up a library's size
+	librarySizePageSize = 500
+)
+
+// librarySorts maps library --sort values to plex sort keys
+var librarySorts = map[string]string{
+	"title": "titleSort:asc",
+	"added": "addedAt:desc",
+	"year":  "year:desc",
+}
+
+// leafTypes are the plex type ids of the files in each kind of library, used to add up sizes
+var leafTypes = map[string]int{
+	"movie":  1,
+	"show":   4,
+	"artist": 10,
+}
+
+// librarySize is the size of a library's files as of measuredAt
+type librarySize struct {
+	size       int64
+	measuredAt time.Time
+	measuring  bool
+}
+
+// librarySizes remembers library sizes keyed by plex server url and section key
+// because adding them up means reading every file of the library
+var librarySizes = struct {
+	sizes map[string]librarySize
+	lock  sync.Mutex
+}{
+	sizes: map[string]librarySize{},
+}
+
+// countItems returns how many items of a library match filter without downloading them
+func countItems(plexClient *plex.Plex, sectionKey, filter string) (int, error) {
+	var result mediaContainer
+
+	query := fmt.Sprintf("/library/sections/%s/all?X-Plex-Container-Start=0&X-Plex-Container-Size=0%s", sectionKey, filter)
+
+	if err := pmsGet(plexClient, query, &result); err != nil {
+		return 0, err
+	}
+
+	return result.MediaContainer.TotalSize, nil
+}
+
+// libraryStats counts the items and files of a library
+func libraryStats(plexClient *plex.Plex, section plex.Directory) (items int, files int, err error) {
+	if items, err = countItems(plexClient, section.Key, ""); err != nil {
+		return 0, 0, err
+	}
+
+	leafType, ok := leafTypes[section.Type]
+
+	if !ok {
+		return items, 0, nil
+	}
+
+	if files, err = countItems(plexClient, section.Key, fmt.Sprintf("&type=%d", leafType)); err != nil {
+		return items, 0, err
+	}
+
+	return items, files, nil
+}
+
+// cachedLibrarySize returns the size of a library's files when we know it
+//
+// sizes that are missing or old are added up in the background for next time
+func cachedLibrarySize(plexClient *plex.Plex, section plex.Directory) (int64, bool) {
+	leafType, ok := leafTypes[section.Type]
+
+	if !ok {
+		return 0, false
+	}
+
+	key := plexClient.URL + "/" + section.Key
+
+	librarySizes.lock.Lock()
+	defer librarySizes.lock.Unlock()
+
+	cached, known := librarySizes.sizes[key]
+
+	if !cached.measuring && time.Since(cached.measuredAt) > librarySizeTTL {
+		librarySizes.sizes[key] = librarySize{size: cached.size, measuredAt: cached.measuredAt, measuring: true}
+
+		go measureLibrary(plexClient, key, section.Key, leafType)
+	}
+
+	return cached.size, known && !cached.measuredAt.IsZero()
+}
+
+// measureLibrary adds up the size of a library's files a page at a time and caches it
+func measureLibrary(plexClient *plex.Plex, key, sectionKey string, leafType int) {
+	var size int64
+	var err error
+
+	for start := 0; ; {
+		var leaves mediaContainer
+
+		query := fmt.Sprintf("/library/sections/%s/all?type=%d&X-Plex-Container-Start=%d&X-Plex-Container-Size=%d",
+			sectionKey, leafType, start, librarySizePageSize)
+
+		if err = pmsGet(plexClient, query, &leaves); err != nil {
+			break
+		}
+
+		for _, item := range leaves.MediaContainer.Metadata {
+			for _, media := range item.Media {
+				for _, part := range media.Part {
+					size += part.Size
+				}
+			}
+		}
+
+		start += len(leaves.MediaContainer.Metadata)
+
+		// servers that don't send totalSize end with a short page
+		done := len(leaves.MediaContainer.Metadata) < librarySizePageSize
+
+		if total := leaves.MediaContainer.TotalSize; total > 0 {
+			done = start >= total
+		}
+
+		if len(leaves.MediaContainer.Metadata) == 0 || done {
+			break
+		}
+	}
+
+	librarySizes.lock.Lock()
+	defer librarySizes.lock.Unlock()
+
+	if err != nil {
+		fmt.Printf("measureLibrary() - could not add up %s: %v\n", key, err)
+
+		cached := librarySizes.sizes[key]
+		cached.measuring = false
+		librarySizes.sizes[key] = cached
+
+		return
+	}
+
+	librarySizes.sizes[key] = librarySize{size: size, measuredAt: time.Now()}
+}
+
+// formatSize turns bytes into something like 1.2 TB
+func formatSize(size int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB", "PB"}
+	value := float64(size)
+	unit := 0
+
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+
+	if unit == 0 {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	return fmt.Sprintf("%.1f %s", value, units[unit])
+}
+
+// library lists the libraries of the plex server or browses one of them
+//
+// command: library | library <name> [page] [--sort added|title|year] [--unwatched]
+func library(commandList d, services *clients) func(m *discordgo.Message, args ...string) bool {
+	return func(m *discordgo.Message, args ...string) bool {
+		channelID := m.ChannelID
+		link := services.getPlexLink(commandList.getGuildID(channelID))
+
+		if !link.authorized() {
+			commandList.showError(channelID, "dobby is not linked to a plex server -- run `link`")
+			return false
+		}
+
+		args, options, err := parseOptions(args, optionList{"sort": true, "unwatched": false})
+
+		if err != nil {
+			commandList.showError(channelID, err.Error())
+			return false
+		}
+
+		sections, err := getLibrarySections(link.client())
+
+		if err != nil {
+			fmt.Printf("library() - could not fetch libraries: %v\n", err)
+			commandList.showError(channelID, "could not fetch the libraries of your plex server")
+			return false
+		}
+
+		if len(args) < 1 {
+			if len(sections) == 0 {
+				commandList.discord.ChannelMessageSend(channelID, "our Plex server has no libraries yet")
+				return true
+			}
+
+			message := "Libraries:\n"
+
+			for _, section := range sections {
+				items, files, err := libraryStats(link.client(), section)
+
+				if err != nil {
+					fmt.Printf("library() - could not count %s: %v\n", section.Title, err)
+					message += fmt.Sprintf("**%s** (%s) - could not count items\n", section.Title, section.Type)
+					continue
+				}
+
+				message += fmt.Sprintf("**%s** (%s) - %d items", section.Title, section.Type, items)
+
+				if section.Type == "show" || section.Type == "artist" {
+					message += fmt.Sprintf(", %d %s", files, map[string]string{"show": "episodes", "artist": "tracks"}[section.Type])
+				}
+
+				if size, ok := cachedLibrarySize(link.client(), section); ok && size > 0 {
+					message += ", " + formatSize(size)
+				}
+
+				message += "\n"
+			}
+
+			message += "use `library <name>` to browse one"
+
+			commandList.discord.ChannelMessageSend(channelID, message)
+
+			return true
+		}
+
+		// library names can have spaces, a trailing number is the page
+		// unless the name without it is not a library, like "Kids 2"
+		page := 1
+		section, err := findLibrarySection(sections, strings.Join(args, " "))
+
+		if len(args) > 1 {
+			if n, atoiErr := strconv.Atoi(args[len(args)-1]); atoiErr == nil {
+				if paged, pagedErr := findLibrarySection(sections, strings.Join(args[:len(args)-1], " ")); pagedErr == nil {
+					if n < 1 {
+						commandList.showError(channelID, fmt.Sprintf("`%d` is not a page number", n))
+						return false
+					}
+
+					page = n
+					section, err = paged, nil
+				}
+			}
+		}
+
+		if err != nil {
+			commandList.showError(channelID, err.Error())
+			return false
+		}
+
+		sortName := "title"
+
+		if value, ok := options["sort"]; ok {
+			sortName = strings.ToLower(value)
+		}
+
+		sortKey, ok := librarySorts[sortName]
+
+		if !ok {
+			commandList.showError(channelID, "`--sort` should be added, title or year")
+			return false
+		}
+
+		query := fmt.Sprintf("/library/sections/%s/all?sort=%s&X-Plex-Container-Start=%d&X-Plex-Container-Size=%d",
+			section.Key, sortKey, (page-1)*libraryPageSize, libraryPageSize)
+
+		if _, unwatched := options["unwatched"]; unwatched {
+			query += "&unwatched=1"
+		}
+
+		var result mediaContainer
+
+		if err := pmsGet(link.client(), query, &result); err != nil {
+			fmt.Printf("library() - could not browse %s: %v\n", section.Title, err)
+			commandList.showError(channelID, fmt.Sprintf("could not browse %s", section.Title))
+			return false
+		}
+
+		total := result.MediaContainer.TotalSize
+		pageCount := (total + libraryPageSize - 1) / libraryPageSize
+
+		if total == 0 {
+			commandList.discord.ChannelMessageSend(channelID, fmt.Sprintf("nothing to show in %s", section.Title))
+			return true
+		}
+
+		if page > pageCount {
+			commandList.showError(channelID, fmt.Sprintf("there are only %d pages", pageCount))
+			return false
+		}
+
+		message := fmt.Sprintf("**%s** - showing %d of %d (page %d/%d):\n\n", section.Title, len(result.MediaContainer.Metadata), total, page, pageCount)
+
+		for _, item := range result.MediaContainer.Metadata {
+			message += item.name()
+
+			switch {
+			case item.LeafCount > 0:
+				message += fmt.Sprintf(" - %d/%d watched", item.ViewedLeafCount, item.LeafCount)
+			case item.ViewCount > 0:
+				message += " - watched"
+			}
+
+			message += "\n"
+		}
+
+		if page < pageCount {
+			message += fmt.Sprintf("\nuse `library %s %d` for the next page", section.Title, page+1)
+		}
+
+		if _, err := commandList.discord.ChannelMessageSend(channelID, message); err != nil {
+			fmt.Printf("library() - message sent to discord failed: %v\n", err)
+		}
+
+		return true
+	}
+}
@@ -0,0 +1,17 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"sync"
+)
+
+// windows has no flock so we only guard against writers within this process
+var fileLock sync.Mutex
+
+func lockFile(filename string) (func(), error) {
+	fileLock.Lock()
+
+	return fileLock.Unlock, nil
+}
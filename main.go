@@ -7,40 +7,43 @@ import (
 	"strings"
 	"sync"
 	"syscall"
-	"time"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/jrudio/go-plex-client"
 )
 
 const (
-	// keyword is the trigger word for our program to listen to
-	keyword         = "dobby"
+	// defaultKeyword is the trigger word for our program to listen to
+	// when one is not set in secrets.toml
+	defaultKeyword  = "dobby"
 	secretsFilepath = "./secrets.toml"
 )
 
 var (
-	keywordLen            = 0
-	commandList           commands
-	isVerbose             bool
-	isPlexTokenAuthorized bool
-	isRequestingPlexPIN   bool
-	version               string
-	versionFlag           *bool
-	plexPIN               chan plex.PinResponse
+	commandList commands
+	isVerbose   bool
+	version     string
+	versionFlag *bool
+	plexPIN     chan plex.PinResponse
 )
 
 type commands interface {
-	execute(channelID, cmd string, args ...string)
+	execute(m *discordgo.Message, cmd string, args ...string)
 	isValid(cmd string) bool
 	showHelp(channelID string)
 	showError(channelID string, msg string)
-	addCommand(cmd string, fn ...func(channelID string, args ...string) bool)
+	addCommand(cmd string, fn ...func(m *discordgo.Message, args ...string) bool)
 }
 
 type serviceCredentials struct {
-	DiscordToken string          `toml:"discordToken"`
-	Plex         plexCredentials `toml:"plex"`
+	DiscordToken string `toml:"discordToken"`
+	Keyword      string `toml:"keyword"`
+	OwnerID      string `toml:"ownerID"`
+	// MultiTenant lets every discord guild link its own plex server
+	MultiTenant bool            `toml:"multiTenant"`
+	Plex        plexCredentials `toml:"plex"`
+	// Guilds holds the plex credentials of each guild keyed by guild id
+	Guilds map[string]plexCredentials `toml:"guilds"`
 }
 
 type plexCredentials struct {
@@ -50,34 +53,53 @@ type plexCredentials struct {
 }
 
 type clients struct {
-	plex *plex.Plex
+	// defaultPlex is the plex server in [plex]
+	defaultPlex *plexLink
+	// guilds holds plex servers linked by a single guild in multi-tenant mode
+	guilds map[string]*plexLink
+	config serviceCredentials
+	// data is what we remember between restarts
+	data *store
 	lock sync.Mutex
 }
 
-func (c *clients) setPlexRequestTimeout(timeout int) {
-	// in seconds
+func (c *clients) getConfig() serviceCredentials {
 	c.lock.Lock()
-	c.plex.HTTPClient.Timeout = time.Duration(timeout) * time.Second
-	c.lock.Unlock()
-}
+	defer c.lock.Unlock()
 
-func (c *clients) setPlexClientID(clientID string) {
-	c.lock.Lock()
-	c.plex.ClientIdentifier = clientID
-	c.plex.Headers.ClientIdentifier = clientID
-	c.lock.Unlock()
+	return c.config
 }
 
-func (c *clients) setPlexToken(authToken string) {
+func (c *clients) setConfig(config serviceCredentials) {
+	if config.Keyword == "" {
+		config.Keyword = defaultKeyword
+	}
+
 	c.lock.Lock()
-	c.plex.Token = authToken
+	c.config = config
 	c.lock.Unlock()
 }
 
-func (c *clients) setPlexHost(host string) {
+// getPlexLink returns the plex server the guild should use
+//
+// in multi-tenant mode every guild gets its own, otherwise everyone shares the default
+func (c *clients) getPlexLink(guildID string) *plexLink {
 	c.lock.Lock()
-	c.plex.URL = host
-	c.lock.Unlock()
+	defer c.lock.Unlock()
+
+	if !c.config.MultiTenant || guildID == "" {
+		return c.defaultPlex
+	}
+
+	if link, ok := c.guilds[guildID]; ok {
+		return link
+	}
+
+	link := newPlexLink(guildID)
+
+	c.guilds[guildID] = link
+
+	return link
 }
 
 func checkErrAndExit(err error) {
@@ -102,73 +124,39 @@ func main() {
 		}
 	}
 
-	if keyword == "" {
-		fmt.Println("a keyword (or trigger) is required for dobby to work")
-		os.Exit(1)
-	}
-
 	services := clients{
-		plex: &plex.Plex{},
-		lock: sync.Mutex{},
+		defaultPlex: newPlexLink(""),
+		guilds:      map[string]*plexLink{},
+		lock:        sync.Mutex{},
 	}
 
-	plexClientID := "Dobby (discord bot)" + version
-
-	if credentials.Plex.Token != "" {
-
-		// initialize plex client
-		services.plex, err = plex.New("", credentials.Plex.Token)
-
-		if err != nil {
-			fmt.Printf("failed to initialize plex client: %v\n", err)
-			return
-		}
-
-		// change plex client information to match Dobby
-		services.setPlexClientID(plexClientID)
+	services.setConfig(credentials)
 
-		// pick a server and test the auth token
-		serverInfo, err := services.plex.GetServersInfo()
+	if services.data, err = loadStore(dataFilepath); err != nil {
+		fmt.Printf("could not read %s: %v\n", dataFilepath, err)
+		os.Exit(1)
+	}
 
-		if err != nil {
-			fmt.Printf("plex.GetServersInfo() - failed testing auth token: %v\n", err)
+	if credentials.Plex.Token != "" {
+		if err := services.defaultPlex.link(credentials.Plex); err != nil {
+			fmt.Println(err)
 			return
 		}
+	}
 
-		// TODO: refactor -- too many if-statements!
-
-		if serverInfo.Size > 0 {
-			plexServer := serverInfo.Server[0]
-			plexHost := plexServer.Scheme + "://" + plexServer.Address + ":" + plexServer.Port
-
-			services.setPlexHost(plexHost)
-
-			// check if plex auth token is valid
-			isOK, err := services.plex.Test()
-
-			if err != nil {
-				fmt.Printf("plex.Test() - auth test failed: %v\n", err)
+	if credentials.MultiTenant {
+		for guildID, guildCredentials := range credentials.Guilds {
+			if guildCredentials.Token == "" {
+				continue
 			}
 
-			if !isOK {
-				fmt.Println("we are not authorized. prompt to authorize plex PIN")
-			} else {
-				isPlexTokenAuthorized = true
+			// a guild failing to link should not take everyone else down
+			if err := services.getPlexLink(guildID).link(guildCredentials); err != nil {
+				fmt.Printf("guild %s: %v\n", guildID, err)
 			}
-		} else {
-			fmt.Println("we are not authorized. prompt to authorize plex PIN")
 		}
-
 	}
 
-	if services.plex.Headers.ClientIdentifier != "Dobby (discord bot)"+version || services.plex.ClientIdentifier != "Dobby (discord bot)"+version {
-		services.setPlexClientID(plexClientID)
-	}
-
-	services.lock = sync.Mutex{}
-
-	services.setPlexRequestTimeout(10)
-
 	plexPIN = make(chan plex.PinResponse)
 
 	// connect to Discord server
@@ -176,14 +164,15 @@ func main() {
 
 	checkErrAndExit(err)
 
-	// get keyword length
-	keywordLen = len(keyword)
-
 	commandList := newDiscord(discord)
 
 	commandList = addCommands(commandList, &services)
 
-	discord.AddHandler(onMsgCreate(commandList))
+	discord.AddHandler(onMsgCreate(commandList, &services))
+	discord.AddHandler(onReactionAdd(commandList, &services))
+	discord.AddHandler(onMemberRemove(commandList, &services))
+	discord.AddHandler(onMemberAdd(commandList, &services))
+	discord.AddHandler(onMemberUpdate(commandList, &services))
 
 	err = discord.Open()
 
@@ -191,8 +180,41 @@ func main() {
 
 	defer discord.Close()
 
+	if credentials.OwnerID == "" {
+		// fall back to whoever owns the bot application
+		if app, err := discord.Application("@me"); err == nil && app.Owner != nil {
+			credentials.OwnerID = app.Owner.ID
+			services.setConfig(credentials)
+		}
+	}
+
+	go watchInvites(commandList, &services)
+	go watchRevocations(commandList, &services)
+	go watchRoles(commandList, &services)
+	go watchTrials(commandList, &services)
+	go watchPrune(commandList, &services)
+	go watchNewMedia(commandList, &services)
+	go watchSessions(commandList, &services)
+
 	fmt.Println("bot is listening...")
 
+	hangup := make(chan os.Signal, 1)
+
+	signal.Notify(hangup, syscall.SIGHUP)
+
+	go func() {
+		for range hangup {
+			changes, err := reloadConfig(commandList, &services)
+
+			if err != nil {
+				fmt.Printf("reload failed: %v\n", err)
+				continue
+			}
+
+			fmt.Printf("reloaded configuration: %s\n", strings.Join(changes, ", "))
+		}
+	}()
+
 	ctrlC := make(chan os.Signal, 1)
 
 	signal.Notify(ctrlC, syscall.SIGINT, syscall.SIGTERM, os.Interrupt, os.Kill)
@@ -200,7 +222,7 @@ func main() {
 	<-ctrlC
 }
 
-func onMsgCreate(commandList commands) func(s *discordgo.Session, m *discordgo.MessageCreate) {
+func onMsgCreate(commandList commands, services *clients) func(s *discordgo.Session, m *discordgo.MessageCreate) {
 	return func(s *discordgo.Session, m *discordgo.MessageCreate) {
 		if m.Author.ID == s.State.User.ID {
 			return
@@ -210,6 +232,8 @@ func onMsgCreate(commandList commands) func(s *discordgo.Session, m *discordgo.M
 			fmt.Println(m.Content)
 		}
 
+		keyword := services.getConfig().Keyword
+		keywordLen := len(keyword)
 		messageLen := len(m.Content)
 
 		if messageLen < keywordLen {
@@ -225,7 +249,7 @@ func onMsgCreate(commandList commands) func(s *discordgo.Session, m *discordgo.M
 		if messageLen > keywordLen {
 			// user has a subcommand
 
-			args := strings.Split(m.Content, " ")
+			args := splitArgs(m.Content)
 			argCount := len(args)
 
 			// remove the keyword
@@ -233,6 +257,11 @@ func onMsgCreate(commandList commands) func(s *discordgo.Session, m *discordgo.M
 
 			argCount--
 
+			if argCount < 1 {
+				commandList.showHelp(m.ChannelID)
+				return
+			}
+
 			subcommand := args[0]
 
 			if !commandList.isValid(subcommand) {
@@ -244,7 +273,7 @@ func onMsgCreate(commandList commands) func(s *discordgo.Session, m *discordgo.M
 			// remove the subcommand
 			args = args[1:argCount]
 
-			commandList.execute(m.ChannelID, subcommand, args...)
+			commandList.execute(m.Message, subcommand, args...)
 		} else {
 			// it's only the keyword so return a list of subcommands
 			commandList.showHelp(m.ChannelID)
@@ -257,13 +286,52 @@ func onMsgCreate(commandList commands) func(s *discordgo.Session, m *discordgo.M
 	}
 }
 
+// addCommands registers every command, replacing the ones registered before in one go
+// so messages that arrive during a reload still find their command
 func addCommands(commandList d, services *clients) d {
+	registry := newDiscord(commandList.discord)
 
 	// clear deletes messages in a channel -- user can delete x messages
-	commandList.addCommand("clear", clearMessages(commandList, services))
+	registry.addCommand("clear", clearMessages(commandList, services))
+
+	// confirm or cancel an action that asked for confirmation
+	registry.addCommand("confirm", confirm(commandList, services))
+	registry.addCommand("cancel", cancel(commandList, services))
 
 	// plex-specific commands
-	commandList.addCommand("invite", displayPlexPIN(commandList, services), invite(commandList, services))
+	registry.addCommand("invite", displayPlexPIN(commandList, services), invite(commandList, services))
+	registry.addCommand("request-access", requestAccess(commandList, services))
+	registry.addCommand("link-account", linkMemberAccount(commandList, services))
+	registry.addCommand("whois", whois(commandList, services))
+	registry.addCommand("redeem", redeem(commandList, services))
+	registry.addCommand("quota", quota(commandList, services))
+	registry.addCommand("search", search(commandList, services))
+	registry.addCommand("library", library(commandList, services))
+	registry.addCommand("recent", recent(commandList, services))
+	registry.addCommand("requests", adminOnly(commandList, services), accessRequests(commandList, services))
+
+	registry.addCommand("friends", adminOnly(commandList, services), friends(commandList, services))
+	registry.addCommand("invites", adminOnly(commandList, services), invites(commandList, services))
+	registry.addCommand("remove-friend", adminOnly(commandList, services), removeFriend(commandList, services))
+	registry.addCommand("settings", adminOnly(commandList, services), changeSettings(commandList, services))
+	registry.addCommand("roles", adminOnly(commandList, services), roles(commandList, services))
+	registry.addCommand("extend", adminOnly(commandList, services), extend(commandList, services))
+	registry.addCommand("invite-bulk", adminOnly(commandList, services), inviteBulk(commandList, services))
+	registry.addCommand("prune", adminOnly(commandList, services), prune(commandList, services))
+	registry.addCommand("nowplaying", adminOnly(commandList, services), nowPlaying(commandList, services))
+	registry.addCommand("kill-stream", adminOnly(commandList, services), killStream(commandList, services))
+	registry.addCommand("stream-limits", adminOnly(commandList, services), streamLimits(commandList, services))
+	registry.addCommand("alerts", adminOnly(commandList, services), alerts(commandList, services))
+	registry.addCommand("announce", adminOnly(commandList, services), announce(commandList, services))
+	registry.addCommand("invite-code", adminOnly(commandList, services), inviteCodes(commandList, services))
+	registry.addCommand("server", adminOnly(commandList, services), selectServer(commandList, services))
+	registry.addCommand("link", adminOnly(commandList, services), linkPlex(commandList, services))
+
+	// owner-only commands
+	registry.addCommand("reload", ownerOnly(commandList, services), reload(commandList, services))
+	registry.addCommand("unlink", ownerOnly(commandList, services), unlink(commandList, services))
+
+	commandList.replaceCommands(registry)
 
 	return commandList
 }
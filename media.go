@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/jrudio/go-plex-client"
+)
+
+// posterFilename is the name posters are attached to discord messages as
+const posterFilename = "poster.jpg"
+
+// mediaItem is the metadata of a movie, show, episode or artist on the plex media server
+//
+// go-plex-client's Metadata does not decode every server version so we only pick what we show
+type mediaItem struct {
+	RatingKey            string `json:"ratingKey"`
+	Key                  string `json:"key"`
+	Type                 string `json:"type"`
+	Title                string `json:"title"`
+	GrandparentTitle     string `json:"grandparentTitle"`
+	GrandparentRatingKey string `json:"grandparentRatingKey"`
+	ParentTitle          string `json:"parentTitle"`
+	ParentIndex          int    `json:"parentIndex"`
+	Index                int    `json:"index"`
+	Year                 int    `json:"year"`
+	LibrarySectionID     int    `json:"librarySectionID"`
+	LibrarySectionTitle  string `json:"librarySectionTitle"`
+	Summary              string `json:"summary"`
+	Thumb                string `json:"thumb"`
+	GrandparentThumb     string `json:"grandparentThumb"`
+	AddedAt              int64  `json:"addedAt"`
+	ViewCount            int    `json:"viewCount"`
+	LeafCount            int    `json:"leafCount"`
+	ViewedLeafCount      int    `json:"viewedLeafCount"`
+	Media                []struct {
+		VideoResolution string `json:"videoResolution"`
+		Bitrate         int    `json:"bitrate"`
+		Part            []struct {
+			Size int64 `json:"size"`
+		} `json:"Part"`
+	} `json:"Media"`
+}
+
+// mediaContainer is the response of most plex media server endpoints
+type mediaContainer struct {
+	MediaContainer struct {
+		Size                int         `json:"size"`
+		TotalSize           int         `json:"totalSize"`
+		LibrarySectionTitle string      `json:"librarySectionTitle"`
+		Metadata            []mediaItem `json:"Metadata"`
+		Hub                 []struct {
+			Type     string      `json:"type"`
+			Metadata []mediaItem `json:"Metadata"`
+		} `json:"Hub"`
+	} `json:"MediaContainer"`
+}
+
+// name is the title of an item, with the show and episode number for episodes
+func (item mediaItem) name() string {
+	switch item.Type {
+	case "episode":
+		return fmt.Sprintf("%s S%02dE%02d · %s", item.GrandparentTitle, item.ParentIndex, item.Index, item.Title)
+	case "season":
+		return item.ParentTitle + " · " + item.Title
+	case "track":
+		return item.GrandparentTitle + " · " + item.Title
+	}
+
+	if item.Year > 0 {
+		return fmt.Sprintf("%s (%d)", item.Title, item.Year)
+	}
+
+	return item.Title
+}
+
+// resolution is the best video resolution of an item, like 1080p or 4k
+func (item mediaItem) resolution() string {
+	best := ""
+
+	for _, media := range item.Media {
+		if resolutionRank(media.VideoResolution) > resolutionRank(best) {
+			best = media.VideoResolution
+		}
+	}
+
+	// plex says 1080 or 720 for hd and sd or 4k for the rest
+	if _, err := strconv.Atoi(best); err != nil {
+		return best
+	}
+
+	return best + "p"
+}
+
+// resolutionRank orders plex video resolutions, 4k above any number of lines and sd below
+func resolutionRank(resolution string) int {
+	if resolution == "4k" {
+		return 1 << 20
+	}
+
+	if lines, err := strconv.Atoi(resolution); err == nil {
+		return lines
+	}
+
+	if resolution == "" {
+		return -1
+	}
+
+	return 0
+}
+
+// link opens the item in the plex web app
+func (item mediaItem) link(machineID string) string {
+	return fmt.Sprintf("https://app.plex.tv/desktop#!/server/%s/details?key=%s",
+		machineID,
+		url.QueryEscape("/library/metadata/"+item.RatingKey))
+}
+
+// getLibrarySections lists the libraries of the plex media server
+func getLibrarySections(plexClient *plex.Plex) ([]plex.Directory, error) {
+	libraries, err := plexClient.GetLibraries()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return libraries.MediaContainer.Directory, nil
+}
+
+// findLibrarySection finds a library by title, ignoring case, or by its key
+func findLibrarySection(sections []plex.Directory, name string) (plex.Directory, error) {
+	titles := make([]string, len(sections))
+
+	for i, section := range sections {
+		if strings.EqualFold(section.Title, name) || section.Key == name {
+			return section, nil
+		}
+
+		titles[i] = section.Title
+	}
+
+	return plex.Directory{}, fmt.Errorf("unknown library `%s`\navailable libraries: %s", name, strings.Join(titles, ", "))
+}
+
+// poster is the thumb of the item or, for episodes, of their show
+func (item mediaItem) poster() string {
+	if item.Type == "episode" && item.GrandparentThumb != "" {
+		return item.GrandparentThumb
+	}
+
+	return item.Thumb
+}
+
+// fetchPoster downloads a small version of a poster from the plex media server
+//
+// posters need our plex token so we can't hand discord a link to them
+func fetchPoster(plexClient *plex.Plex, thumb string) ([]byte, error) {
+	if thumb == "" {
+		return nil, errors.New("no poster")
+	}
+
+	resp, err := pmsRequest(plexClient, "GET", "/photo/:/transcode?width=240&height=360&minSize=1&url="+url.QueryEscape(thumb))
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(resp.Status)
+	}
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+// sendMediaEmbed posts an embed with the poster at thumb attached, or without a poster if it can't be fetched
+func sendMediaEmbed(commandList d, plexClient *plex.Plex, channelID string, embed *discordgo.MessageEmbed, thumb string) error {
+	message := &discordgo.MessageSend{Embed: embed}
+
+	if poster, err := fetchPoster(plexClient, thumb); err != nil {
+		if isVerbose {
+			fmt.Printf("sendMediaEmbed() - could not fetch poster %s: %v\n", thumb, err)
+		}
+	} else {
+		embed.Thumbnail = &discordgo.MessageEmbedThumbnail{URL: "attachment://" + posterFilename}
+		message.Files = []*discordgo.File{{Name: posterFilename, ContentType: "image/jpeg", Reader: bytes.NewReader(poster)}}
+	}
+
+	_, err := commandList.discord.ChannelMessageSendComplex(channelID, message)
+
+	return err
+}
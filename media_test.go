@@ -0,0 +1,35 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestResolution(t *testing.T) {
+	tests := []struct {
+		name  string
+		media string
+		want  string
+	}{
+		{name: "no media", media: `[]`, want: ""},
+		{name: "one file", media: `[{"videoResolution":"720"}]`, want: "720p"},
+		{name: "higher number wins", media: `[{"videoResolution":"720"},{"videoResolution":"1080"}]`, want: "1080p"},
+		{name: "4k wins", media: `[{"videoResolution":"1080"},{"videoResolution":"4k"},{"videoResolution":"720"}]`, want: "4k"},
+		{name: "sd is lowest", media: `[{"videoResolution":"sd"},{"videoResolution":"480"}]`, want: "480p"},
+		{name: "only sd", media: `[{"videoResolution":"sd"}]`, want: "sd"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var item mediaItem
+
+			if err := json.Unmarshal([]byte(`{"Media":`+test.media+`}`), &item); err != nil {
+				t.Fatalf("could not decode media: %v", err)
+			}
+
+			if got := item.resolution(); got != test.want {
+				t.Errorf("resolution() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// revocationCheckInterval is how often we look for scheduled revocations that are due
+const revocationCheckInterval = 5 * time.Minute
+
+// scheduledRevocation is plex access that gets removed once a departed member's grace period ends
+type scheduledRevocation struct {
+	GuildID   string    `toml:"guildID"`
+	DiscordID string    `toml:"discordID"`
+	PlexUser  string    `toml:"plexUser"`
+	RevokeAt  time.Time `toml:"revokeAt"`
+}
+
+// onMemberRemove applies the guild's leave policy to members that leave
+func onMemberRemove(commandList d, services *clients) func(s *discordgo.Session, event *discordgo.GuildMemberRemove) {
+	return func(s *discordgo.Session, event *discordgo.GuildMemberRemove) {
+		if event.Member == nil || event.User == nil {
+			return
+		}
+
+		guildID := event.GuildID
+		discordID := event.User.ID
+
+		account, ok := findAccountByDiscord(services, guildID, discordID)
+
+		if !ok {
+			return
+		}
+
+		settings := services.getGuildSettings(guildID)
+		member := fmt.Sprintf("%s#%s", event.User.Username, event.User.Discriminator)
+
+		switch settings.LeavePolicy {
+		case leavePolicyAlert:
+			alertAdmins(commandList, services, guildID, fmt.Sprintf(
+				"%s left the server but still has access to Plex as `%s` -- `remove-friend %s` to revoke it",
+				member, account.PlexUser, account.PlexUser))
+
+		case leavePolicyRevoke:
+			if _, err := revokeAccess(services, guildID, account.PlexUser); err != nil {
+				fmt.Printf("onMemberRemove() - could not revoke %s: %v\n", account.PlexUser, err)
+				alertAdmins(commandList, services, guildID, fmt.Sprintf(
+					"%s left the server but their Plex access as `%s` could not be revoked: %v",
+					member, account.PlexUser, err))
+				return
+			}
+
+			audit(guildID, s.State.User.ID, "leave-revoke", fmt.Sprintf("member=%s user=%s", discordID, account.PlexUser))
+
+			alertAdmins(commandList, services, guildID, fmt.Sprintf(
+				"%s left the server, their Plex access as `%s` was revoked", member, account.PlexUser))
+
+		case leavePolicyGrace:
+			grace := settings.LeaveGrace
+
+			if grace == "" {
+				grace = defaultLeaveGrace
+			}
+
+			duration, err := parseDuration(grace)
+
+			if err != nil {
+				fmt.Printf("onMemberRemove() - bad leave grace %s: %v\n", grace, err)
+				return
+			}
+
+			revocation := scheduledRevocation{
+				GuildID:   guildID,
+				DiscordID: discordID,
+				PlexUser:  account.PlexUser,
+				RevokeAt:  time.Now().Add(duration),
+			}
+
+			err = services.data.update(func(data *dobbyData) {
+				data.Revocations = append(data.Revocations, revocation)
+			})
+
+			if err != nil {
+				fmt.Printf("onMemberRemove() - could not save revocation: %v\n", err)
+				return
+			}
+
+			audit(guildID, s.State.User.ID, "leave-schedule-revoke", fmt.Sprintf("member=%s user=%s at=%s", discordID, account.PlexUser, revocation.RevokeAt.Format(time.RFC3339)))
+
+			alertAdmins(commandList, services, guildID, fmt.Sprintf(
+				"%s left the server, their Plex access as `%s` will be revoked in %s unless they come back",
+				member, account.PlexUser, grace))
+		}
+	}
+}
+
+// onMemberAdd cancels the scheduled revocation of members that come back
+func onMemberAdd(commandList d, services *clients) func(s *discordgo.Session, event *discordgo.GuildMemberAdd) {
+	return func(s *discordgo.Session, event *discordgo.GuildMemberAdd) {
+		if event.Member == nil || event.User == nil {
+			return
+		}
+
+		cancelled := []scheduledRevocation{}
+
+		err := services.data.update(func(data *dobbyData) {
+			revocations := data.Revocations[:0]
+
+			for _, revocation := range data.Revocations {
+				if revocation.GuildID == event.GuildID && revocation.DiscordID == event.User.ID {
+					cancelled = append(cancelled, revocation)
+					continue
+				}
+
+				revocations = append(revocations, revocation)
+			}
+
+			data.Revocations = revocations
+		})
+
+		if err != nil {
+			fmt.Printf("onMemberAdd() - could not save revocations: %v\n", err)
+			return
+		}
+
+		for _, revocation := range cancelled {
+			alertAdmins(commandList, services, revocation.GuildID, fmt.Sprintf(
+				"<@%s> came back, their Plex access as `%s` will not be revoked",
+				revocation.DiscordID, revocation.PlexUser))
+		}
+	}
+}
+
+// watchRevocations periodically revokes the plex access of members whose grace period ended
+func watchRevocations(commandList d, services *clients) {
+	for {
+		time.Sleep(revocationCheckInterval)
+
+		checkRevocations(commandList, services)
+	}
+}
+
+func checkRevocations(commandList d, services *clients) {
+	due := []scheduledRevocation{}
+	now := time.Now()
+
+	services.data.view(func(data dobbyData) {
+		for _, revocation := range data.Revocations {
+			if now.After(revocation.RevokeAt) {
+				due = append(due, revocation)
+			}
+		}
+	})
+
+	for _, revocation := range due {
+		message := fmt.Sprintf("grace period of <@%s> ended, their Plex access as `%s` was revoked", revocation.DiscordID, revocation.PlexUser)
+
+		_, err := revokeAccess(services, revocation.GuildID, revocation.PlexUser)
+
+		if err != nil && !errors.Is(err, errNoAccess) {
+			// keep the revocation around and try again on the next check
+			fmt.Printf("checkRevocations() - could not revoke %s: %v\n", revocation.PlexUser, err)
+			continue
+		}
+
+		if err == nil {
+			audit(revocation.GuildID, commandList.discord.State.User.ID, "leave-revoke", fmt.Sprintf("member=%s user=%s", revocation.DiscordID, revocation.PlexUser))
+		} else {
+			message = fmt.Sprintf("grace period of <@%s> ended, their Plex access as `%s` was already removed", revocation.DiscordID, revocation.PlexUser)
+		}
+
+		err = services.data.update(func(data *dobbyData) {
+			revocations := data.Revocations[:0]
+
+			for _, scheduled := range data.Revocations {
+				if scheduled == revocation {
+					continue
+				}
+
+				revocations = append(revocations, scheduled)
+			}
+
+			data.Revocations = revocations
+		})
+
+		if err != nil {
+			fmt.Printf("checkRevocations() - could not save revocations: %v\n", err)
+		}
+
+		alertAdmins(commandList, services, revocation.GuildID, message)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/jrudio/go-plex-client"
+)
+
+// plexapi.go holds requests to plex.tv and plex media servers that go-plex-client does not cover
+
+const plexTVURL = "https://plex.tv"
+
+// plexTVRequest sends an authenticated request to plex.tv
+//
+// a non-nil body is sent as json and the response is requested as json,
+// otherwise the response is requested as xml
+func plexTVRequest(plexClient *plex.Plex, method, query string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, query, bytes.NewBuffer(body))
+
+	if err != nil {
+		return &http.Response{}, err
+	}
+
+	if body != nil {
+		req.Header.Set("Content-type", "application/json")
+		req.Header.Set("Accept", "application/json")
+	} else {
+		req.Header.Set("Accept", "application/xml")
+	}
+
+	req.Header.Set("X-Plex-Token", plexClient.Token)
+	req.Header.Set("X-Plex-Client-Identifier", plexClient.ClientIdentifier)
+	req.Header.Set("X-Plex-Product", plexClient.Headers.Product)
+
+	return plexClient.HTTPClient.Do(req)
+}
+
+// pmsRequest sends an authenticated request to the plex media server
+func pmsRequest(plexClient *plex.Plex, method, query string) (*http.Response, error) {
+	req, err := http.NewRequest(method, plexClient.URL+query, nil)
+
+	if err != nil {
+		return &http.Response{}, err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-Plex-Token", plexClient.Token)
+	req.Header.Set("X-Plex-Client-Identifier", plexClient.ClientIdentifier)
+
+	return plexClient.HTTPClient.Do(req)
+}
+
+// pmsGet sends an authenticated GET request to the plex media server and decodes the json response into result
+func pmsGet(plexClient *plex.Plex, query string, result interface{}) error {
+	resp, err := pmsRequest(plexClient, "GET", query)
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(result)
+}
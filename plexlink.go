@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jrudio/go-plex-client"
+)
+
+// plexLink is a plex server that dobby is linked to
+type plexLink struct {
+	// plex is replaced by newPlexClient and updateClient, use client() to read it
+	plex *plex.Plex
+	// guildID is empty for the default plex server
+	guildID string
+	// isAuthorized and isRequestingPIN are read from several goroutines, use their methods
+	isAuthorized    bool
+	isRequestingPIN bool
+	lock            sync.Mutex
+}
+
+func newPlexLink(guildID string) *plexLink {
+	link := &plexLink{
+		plex:    &plex.Plex{},
+		guildID: guildID,
+	}
+
+	link.setPlexClientID("Dobby (discord bot)" + version)
+	link.setPlexRequestTimeout(10)
+
+	return link
+}
+
+// credentialsTable is where this link's credentials live in secrets.toml
+func (l *plexLink) credentialsTable() string {
+	if l.guildID == "" {
+		return "plex"
+	}
+
+	return "guilds." + l.guildID
+}
+
+// link sets up a plex client from credentials and checks that we are authorized
+func (l *plexLink) link(credentials plexCredentials) error {
+	if err := l.newPlexClient(credentials.Token); err != nil {
+		return fmt.Errorf("failed to initialize plex client: %v", err)
+	}
+
+	return l.authorize(credentials.Host)
+}
+
+// newPlexClient replaces our plex client with a fresh one that uses authToken
+func (l *plexLink) newPlexClient(authToken string) error {
+	plexClient, err := plex.New("", authToken)
+
+	if err != nil {
+		return err
+	}
+
+	l.lock.Lock()
+	l.plex = plexClient
+	l.lock.Unlock()
+
+	// change plex client information to match Dobby
+	l.setPlexClientID("Dobby (discord bot)" + version)
+	l.setPlexRequestTimeout(10)
+
+	return nil
+}
+
+// client returns the plex client of the link
+//
+// the client is never changed in place, updateClient swaps in a new one, so it is safe
+// to keep using what this returns while a reload or link replaces it
+func (l *plexLink) client() *plex.Plex {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.plex
+}
+
+// updateClient changes a copy of the plex client and swaps it in
+func (l *plexLink) updateClient(update func(plexClient *plex.Plex)) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	plexClient := *l.plex
+	update(&plexClient)
+	l.plex = &plexClient
+}
+
+func (l *plexLink) setPlexRequestTimeout(timeout int) {
+	// in seconds
+	l.updateClient(func(plexClient *plex.Plex) {
+		plexClient.HTTPClient.Timeout = time.Duration(timeout) * time.Second
+	})
+}
+
+func (l *plexLink) setPlexClientID(clientID string) {
+	l.updateClient(func(plexClient *plex.Plex) {
+		plexClient.ClientIdentifier = clientID
+		plexClient.Headers.ClientIdentifier = clientID
+	})
+}
+
+func (l *plexLink) setPlexToken(authToken string) {
+	l.updateClient(func(plexClient *plex.Plex) {
+		plexClient.Token = authToken
+	})
+}
+
+func (l *plexLink) setPlexHost(host string) {
+	l.updateClient(func(plexClient *plex.Plex) {
+		plexClient.URL = host
+	})
+}
+
+// authorized is true when our plex token works on the linked server
+func (l *plexLink) authorized() bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.isAuthorized
+}
+
+func (l *plexLink) setAuthorized(authorized bool) {
+	l.lock.Lock()
+	l.isAuthorized = authorized
+	l.lock.Unlock()
+}
+
+// startPINRequest marks the link as waiting for a plex PIN and returns false if it already was
+func (l *plexLink) startPINRequest() bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.isRequestingPIN {
+		return false
+	}
+
+	l.isRequestingPIN = true
+
+	return true
+}
+
+func (l *plexLink) finishPINRequest() {
+	l.lock.Lock()
+	l.isRequestingPIN = false
+	l.lock.Unlock()
+}
+
+// authorize picks a server for our plex token and checks that we are allowed to use it
+//
+// host is optional -- without it we use the first server tied to the token
+func (l *plexLink) authorize(host string) error {
+	l.setAuthorized(false)
+
+	// pick a server and test the auth token
+	serverInfo, err := l.client().GetServersInfo()
+
+	if err != nil {
+		return fmt.Errorf("plex.GetServersInfo() - failed testing auth token: %v", err)
+	}
+
+	if serverInfo.Size < 1 {
+		fmt.Println("we are not authorized. prompt to authorize plex PIN")
+		return nil
+	}
+
+	if host == "" {
+		plexServer := serverInfo.Server[0]
+		host = plexServer.Scheme + "://" + plexServer.Address + ":" + plexServer.Port
+	}
+
+	l.setPlexHost(host)
+
+	// check if plex auth token is valid
+	isOK, err := l.client().Test()
+
+	if err != nil {
+		fmt.Printf("plex.Test() - auth test failed: %v\n", err)
+	}
+
+	if !isOK {
+		fmt.Println("we are not authorized. prompt to authorize plex PIN")
+	} else {
+		l.setAuthorized(true)
+	}
+
+	return nil
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	// pruneCheckInterval is how often auto-prune looks for inactive users
+	pruneCheckInterval = 24 * time.Hour
+	// pruneListLength caps how many users are listed in a message
+	pruneListLength = 30
+	// minPruneWindow keeps a typo like 1h or 0d from removing everyone
+	minPruneWindow = 7 * 24 * time.Hour
+)
+
+// parsePruneWindow reads how long users must be inactive before they are pruned
+func parsePruneWindow(s string) (time.Duration, error) {
+	window, err := parseDuration(s)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if window < minPruneWindow {
+		return 0, fmt.Errorf("`%s` is too short -- users must be inactive for at least 7d before they are pruned", s)
+	}
+
+	return window, nil
+}
+
+// findInactive returns the users that accepted their invite before the window started
+// and have not streamed anything since
+func findInactive(link *plexLink, window time.Duration) (string, []sharedServer, error) {
+	if !link.authorized() {
+		return "", nil, errors.New("dobby is not linked to a plex server -- run `link`")
+	}
+
+	machineID, err := link.client().GetMachineID()
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	shared, err := getSharedServers(link.client(), machineID)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	since := time.Now().Add(-window)
+
+	streams, err := getStreamsSince(link.client(), since)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	inactive := []sharedServer{}
+
+	for _, user := range shared {
+		// pending invites are handled by `invites cancel`
+		if user.isPending() || time.Unix(user.AcceptedAt, 0).After(since) {
+			continue
+		}
+
+		if _, streamed := streams[user.UserID]; streamed {
+			continue
+		}
+
+		inactive = append(inactive, user)
+	}
+
+	sort.Slice(inactive, func(i, j int) bool {
+		return strings.ToLower(inactive[i].name()) < strings.ToLower(inactive[j].name())
+	})
+
+	return machineID, inactive, nil
+}
+
+// listUsers names users for a discord message, cutting long lists short
+func listUsers(users []sharedServer) string {
+	names := []string{}
+
+	for i, user := range users {
+		if i == pruneListLength {
+			names = append(names, fmt.Sprintf("...and %d more", len(users)-pruneListLength))
+			break
+		}
+
+		names = append(names, "`"+user.name()+"`")
+	}
+
+	return strings.Join(names, ", ")
+}
+
+// forgetPlexUser drops the linked account and trial of a plex user that no longer has access
+func forgetPlexUser(services *clients, guildID string, user sharedServer) error {
+	return services.data.update(func(data *dobbyData) {
+		accounts := data.Accounts[:0]
+
+		for _, account := range data.Accounts {
+			if account.GuildID != guildID || !account.matches(user) {
+				accounts = append(accounts, account)
+			}
+		}
+
+		data.Accounts = accounts
+
+		trials := data.Trials[:0]
+
+		for _, t := range data.Trials {
+			if t.GuildID != guildID || !(strings.EqualFold(t.UsernameOrEmail, user.Username) || strings.EqualFold(t.UsernameOrEmail, user.Email)) {
+				trials = append(trials, t)
+			}
+		}
+
+		data.Trials = trials
+	})
+}
+
+// pruneUsers removes users from the plex server and returns the ones that were and weren't removed
+func pruneUsers(services *clients, link *plexLink, machineID, guildID, actorID, window string, users []sharedServer) (removed []sharedServer, failed []sharedServer) {
+
+	for _, user := range users {
+		if err := removeSharedServer(link.client(), machineID, user); err != nil {
+			fmt.Printf("pruneUsers() - could not remove %s: %v\n", user.name(), err)
+			failed = append(failed, user)
+			continue
+		}
+
+		if err := forgetPlexUser(services, guildID, user); err != nil {
+			fmt.Printf("pruneUsers() - could not forget %s: %v\n", user.name(), err)
+		}
+
+		audit(guildID, actorID, "prune", fmt.Sprintf("user=%s email=%s inactive=%s", user.Username, user.Email, window))
+
+		removed = append(removed, user)
+	}
+
+	return removed, failed
+}
+
+// prune removes users that have not streamed anything for a while
+//
+// command: prune --inactive 90d
+func prune(commandList d, services *clients) func(m *discordgo.Message, args ...string) bool {
+	return func(m *discordgo.Message, args ...string) bool {
+		channelID := m.ChannelID
+		guildID := commandList.getGuildID(channelID)
+		link := services.getPlexLink(guildID)
+
+		_, options, err := parseOptions(args, optionList{"inactive": true})
+
+		if err != nil {
+			commandList.showError(channelID, err.Error())
+			return false
+		}
+
+		inactive, ok := options["inactive"]
+
+		if !ok {
+			commandList.showError(channelID, "usage: `prune --inactive 90d`")
+			return false
+		}
+
+		window, err := parsePruneWindow(inactive)
+
+		if err != nil {
+			commandList.showError(channelID, err.Error())
+			return false
+		}
+
+		machineID, users, err := findInactive(link, window)
+
+		if err != nil {
+			fmt.Printf("prune() - could not find inactive users: %v\n", err)
+			commandList.showError(channelID, fmt.Sprintf("could not find inactive users: %v", err))
+			return false
+		}
+
+		if len(users) == 0 {
+			commandList.discord.ChannelMessageSend(channelID, fmt.Sprintf("everyone has streamed something in the last %s", inactive))
+			return true
+		}
+
+		description := fmt.Sprintf("%d users have not streamed anything in the last %s: %s\nremove their access?", len(users), inactive, listUsers(users))
+
+		askConfirmation(commandList, m, description, func() {
+			removed, failed := pruneUsers(services, link, machineID, guildID, m.Author.ID, inactive, users)
+
+			message := fmt.Sprintf("removed %d inactive users", len(removed))
+
+			if len(failed) > 0 {
+				message += "\ncould not remove " + listUsers(failed)
+			}
+
+			commandList.discord.ChannelMessageSend(channelID, message)
+		})
+
+		return true
+	}
+}
+
+// watchPrune removes inactive users every day in guilds that turned on auto-prune
+func watchPrune(commandList d, services *clients) {
+	for {
+		time.Sleep(pruneCheckInterval)
+
+		policies := map[string]string{}
+
+		services.data.view(func(data dobbyData) {
+			for guildID, settings := range data.Guilds {
+				if settings.AutoPrune != "" {
+					policies[guildID] = settings.AutoPrune
+				}
+			}
+		})
+
+		for guildID, inactive := range policies {
+			window, err := parsePruneWindow(inactive)
+
+			if err != nil {
+				fmt.Printf("watchPrune() - bad auto-prune %s in guild %s: %v\n", inactive, guildID, err)
+				continue
+			}
+
+			link := services.getPlexLink(guildID)
+
+			machineID, users, err := findInactive(link, window)
+
+			if err != nil {
+				fmt.Printf("watchPrune() - could not find inactive users in guild %s: %v\n", guildID, err)
+				continue
+			}
+
+			if len(users) == 0 {
+				continue
+			}
+
+			removed, failed := pruneUsers(services, link, machineID, guildID, commandList.discord.State.User.ID, inactive, users)
+
+			message := fmt.Sprintf("auto-prune removed %d users that have not streamed anything in the last %s", len(removed), inactive)
+
+			if len(removed) > 0 {
+				message += ": " + listUsers(removed)
+			}
+
+			if len(failed) > 0 {
+				message += "\ncould not remove " + listUsers(failed)
+			}
+
+			alertAdmins(commandList, services, guildID, message)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// memberQuota is how many invites a member has sent and, when set, their own limit
+type memberQuota struct {
+	GuildID   string `toml:"guildID"`
+	DiscordID string `toml:"discordID"`
+	Used      int    `toml:"used"`
+	// Limit overrides the guild's invite-quota. 0 uses the guild's, -1 is no limit
+	Limit int `toml:"limit"`
+}
+
+// getQuota returns how many invites a member sent and how many they may send, 0 being no limit
+func getQuota(services *clients, guildID, discordID string) (used int, limit int) {
+	services.data.view(func(data dobbyData) {
+		used, limit = quotaOf(data, guildID, discordID)
+	})
+
+	return used, limit
+}
+
+// quotaOf is getQuota for data we already hold
+func quotaOf(data dobbyData, guildID, discordID string) (used int, limit int) {
+	limit = data.Guilds[guildID].InviteQuota
+
+	for _, quota := range data.Quotas {
+		if quota.GuildID != guildID || quota.DiscordID != discordID {
+			continue
+		}
+
+		used = quota.Used
+
+		switch {
+		case quota.Limit < 0:
+			limit = 0
+		case quota.Limit > 0:
+			limit = quota.Limit
+		}
+	}
+
+	return used, limit
+}
+
+// updateQuota changes a member's quota, adding it when they have none yet
+func updateQuota(services *clients, guildID, discordID string, update func(quota *memberQuota)) error {
+	return services.data.update(func(data *dobbyData) {
+		for i := range data.Quotas {
+			if data.Quotas[i].GuildID == guildID && data.Quotas[i].DiscordID == discordID {
+				update(&data.Quotas[i])
+				return
+			}
+		}
+
+		quota := memberQuota{GuildID: guildID, DiscordID: discordID}
+		update(&quota)
+		data.Quotas = append(data.Quotas, quota)
+	})
+}
+
+// claimQuota counts an invite a member is about to send, or returns an error when they have none left
+//
+// the check and the count happen in one update so two invites can't both take the last one
+func claimQuota(services *clients, guildID, discordID string) error {
+	var claimErr error
+
+	err := services.data.update(func(data *dobbyData) {
+		used, limit := quotaOf(*data, guildID, discordID)
+
+		if limit > 0 && used >= limit {
+			claimErr = fmt.Errorf("you have used all %d of your invites -- ask an admin for more", limit)
+			return
+		}
+
+		for i := range data.Quotas {
+			if data.Quotas[i].GuildID == guildID && data.Quotas[i].DiscordID == discordID {
+				data.Quotas[i].Used++
+				return
+			}
+		}
+
+		data.Quotas = append(data.Quotas, memberQuota{GuildID: guildID, DiscordID: discordID, Used: 1})
+	})
+
+	if err != nil {
+		fmt.Printf("claimQuota() - could not save quotas: %v\n", err)
+		return errors.New("`internal error - could not save quotas`")
+	}
+
+	return claimErr
+}
+
+// releaseQuota gives back an invite claimed by claimQuota that could not be sent
+func releaseQuota(services *clients, guildID, discordID string) {
+	err := updateQuota(services, guildID, discordID, func(quota *memberQuota) {
+		if quota.Used > 0 {
+			quota.Used--
+		}
+	})
+
+	if err != nil {
+		fmt.Printf("releaseQuota() - could not save quotas: %v\n", err)
+	}
+}
+
+// describeQuota describes how many invites a member has left
+func describeQuota(used, limit int) string {
+	if limit == 0 {
+		return fmt.Sprintf("%d invites sent, no limit", used)
+	}
+
+	left := limit - used
+
+	if left < 0 {
+		left = 0
+	}
+
+	return fmt.Sprintf("%d of %d invites sent, %d left", used, limit, left)
+}
+
+// quota shows or changes how many invites members can send
+//
+// command: quota | quota @member | quota @member <limit|unlimited|default|reset>
+func quota(commandList d, services *clients) func(m *discordgo.Message, args ...string) bool {
+	return func(m *discordgo.Message, args ...string) bool {
+		channelID := m.ChannelID
+		guildID := commandList.getGuildID(channelID)
+
+		if len(args) < 1 {
+			used, limit := getQuota(services, guildID, m.Author.ID)
+			commandList.discord.ChannelMessageSend(channelID, "you have "+describeQuota(used, limit))
+			return true
+		}
+
+		discordID, ok := parseMention(args[0])
+
+		if !ok {
+			commandList.showError(channelID, "usage: `quota`, `quota @member` or `quota @member <limit|unlimited|default|reset>`")
+			return false
+		}
+
+		if discordID != m.Author.ID && !isAdmin(commandList, services, m.Author.ID, channelID) {
+			commandList.showError(channelID, "only admins can look at someone else's quota")
+			return false
+		}
+
+		if len(args) < 2 {
+			used, limit := getQuota(services, guildID, discordID)
+			commandList.discord.ChannelMessageSend(channelID, fmt.Sprintf("<@%s> has %s", discordID, describeQuota(used, limit)))
+			return true
+		}
+
+		if !isAdmin(commandList, services, m.Author.ID, channelID) {
+			commandList.showError(channelID, "only admins can change quotas")
+			return false
+		}
+
+		var update func(quota *memberQuota)
+
+		switch args[1] {
+		case "unlimited":
+			update = func(quota *memberQuota) { quota.Limit = -1 }
+		case "default":
+			update = func(quota *memberQuota) { quota.Limit = 0 }
+		case "reset":
+			update = func(quota *memberQuota) { quota.Used = 0 }
+		default:
+			limit, err := strconv.Atoi(args[1])
+
+			if err != nil || limit < 1 {
+				commandList.showError(channelID, fmt.Sprintf("`%s` should be a number of invites, `unlimited`, `default` or `reset`", args[1]))
+				return false
+			}
+
+			update = func(quota *memberQuota) { quota.Limit = limit }
+		}
+
+		if err := updateQuota(services, guildID, discordID, update); err != nil {
+			fmt.Printf("quota() - could not save quotas: %v\n", err)
+			commandList.showError(channelID, "`internal error - could not save quotas`")
+			return false
+		}
+
+		audit(guildID, m.Author.ID, "quota", fmt.Sprintf("member=%s change=%s", discordID, args[1]))
+
+		used, limit := getQuota(services, guildID, discordID)
+		commandList.discord.ChannelMessageSend(channelID, fmt.Sprintf("<@%s> now has %s", discordID, describeQuota(used, limit)))
+
+		return true
+	}
+}
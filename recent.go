@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/jrudio/go-plex-client"
+)
+
+const (
+	defaultRecentCount = 5
+	maxRecentCount     = 10
+	// recentEpisodeLines caps how many episodes of a show an embed lists
+	recentEpisodeLines = 10
+)
+
+// recentTypes are the plex type ids recent looks at for each kind of library
+var recentTypes = map[string]int{
+	"movie": 1,
+	"show":  4,
+}
+
+// getRecentlyAdded returns the newest movies and episodes of the given libraries, newest first
+func getRecentlyAdded(plexClient *plex.Plex, sections []plex.Directory, size int) ([]mediaItem, error) {
+	items := []mediaItem{}
+
+	for _, section := range sections {
+		mediaType, ok := recentTypes[section.Type]
+
+		if !ok {
+			continue
+		}
+
+		var result mediaContainer
+
+		query := fmt.Sprintf("/library/sections/%s/all?type=%d&sort=addedAt:desc&X-Plex-Container-Start=0&X-Plex-Container-Size=%d", section.Key, mediaType, size)
+
+		if err := pmsGet(plexClient, query, &result); err != nil {
+			return nil, err
+		}
+
+		// items of a section don't say which section they are in, the container does
+		sectionID, _ := strconv.Atoi(section.Key)
+
+		for _, item := range result.MediaContainer.Metadata {
+			item.LibrarySectionID = sectionID
+
+			if item.LibrarySectionTitle == "" {
+				item.LibrarySectionTitle = section.Title
+			}
+
+			items = append(items, item)
+		}
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].AddedAt > items[j].AddedAt
+	})
+
+	return items, nil
+}
+
+// groupByShow puts episodes of the same show together and keeps everything else on its own,
+// in the order each group first shows up
+func groupByShow(items []mediaItem) [][]mediaItem {
+	groups := [][]mediaItem{}
+	shows := map[string]int{}
+
+	for _, item := range items {
+		if item.Type != "episode" || item.GrandparentRatingKey == "" {
+			groups = append(groups, []mediaItem{item})
+			continue
+		}
+
+		if i, ok := shows[item.GrandparentRatingKey]; ok {
+			groups[i] = append(groups[i], item)
+			continue
+		}
+
+		shows[item.GrandparentRatingKey] = len(groups)
+		groups = append(groups, []mediaItem{item})
+	}
+
+	return groups
+}
+
+// groupEmbed describes a movie, or new episodes of a show, with the poster to attach
+func groupEmbed(group []mediaItem, machineID string) (*discordgo.MessageEmbed, string) {
+	first := group[0]
+
+	embed := &discordgo.MessageEmbed{
+		Footer: &discordgo.MessageEmbedFooter{Text: first.LibrarySectionTitle},
+	}
+
+	if first.Type != "episode" {
+		embed.Title = first.name()
+		embed.URL = first.link(machineID)
+		embed.Description = truncate(first.Summary, 300)
+
+		if resolution := first.resolution(); resolution != "" {
+			embed.Fields = []*discordgo.MessageEmbedField{{Name: "Resolution", Value: resolution, Inline: true}}
+		}
+
+		return embed, first.poster()
+	}
+
+	show := mediaItem{RatingKey: first.GrandparentRatingKey}
+
+	embed.Title = first.GrandparentTitle
+	embed.URL = show.link(machineID)
+
+	lines := []string{}
+
+	for i, episode := range group {
+		if i == recentEpisodeLines {
+			lines = append(lines, fmt.Sprintf("...and %d more", len(group)-recentEpisodeLines))
+			break
+		}
+
+		lines = append(lines, fmt.Sprintf("[S%02dE%02d · %s](%s)", episode.ParentIndex, episode.Index, episode.Title, episode.link(machineID)))
+	}
+
+	if len(group) == 1 {
+		embed.Description = "New episode:\n" + strings.Join(lines, "\n")
+	} else {
+		embed.Description = fmt.Sprintf("%d new episodes:\n", len(group)) + strings.Join(lines, "\n")
+	}
+
+	return embed, first.poster()
+}
+
+// truncate shortens text to at most length characters
+func truncate(text string, length int) string {
+	runes := []rune(text)
+
+	if len(runes) <= length {
+		return text
+	}
+
+	return strings.TrimSpace(string(runes[:length-1])) + "…"
+}
+
+// recent shows what was added to the plex server lately
+//
+// command: recent [library] [--count 5]
+func recent(commandList d, services *clients) func(m *discordgo.Message, args ...string) bool {
+	return func(m *discordgo.Message, args ...string) bool {
+		channelID := m.ChannelID
+		link := services.getPlexLink(commandList.getGuildID(channelID))
+
+		if !link.authorized() {
+			commandList.showError(channelID, "dobby is not linked to a plex server -- run `link`")
+			return false
+		}
+
+		args, options, err := parseOptions(args, optionList{"count": true})
+
+		if err != nil {
+			commandList.showError(channelID, err.Error())
+			return false
+		}
+
+		count := defaultRecentCount
+
+		if value, ok := options["count"]; ok {
+			var err error
+
+			if count, err = strconv.Atoi(value); err != nil || count < 1 || count > maxRecentCount {
+				commandList.showError(channelID, fmt.Sprintf("`--count` should be a number from 1 to %d", maxRecentCount))
+				return false
+			}
+		}
+
+		sections, err := getLibrarySections(link.client())
+
+		if err != nil {
+			fmt.Printf("recent() - could not fetch libraries: %v\n", err)
+			commandList.showError(channelID, "could not fetch the libraries of your plex server")
+			return false
+		}
+
+		if len(args) > 0 {
+			section, err := findLibrarySection(sections, strings.Join(args, " "))
+
+			if err != nil {
+				commandList.showError(channelID, err.Error())
+				return false
+			}
+
+			if _, ok := recentTypes[section.Type]; !ok {
+				commandList.showError(channelID, fmt.Sprintf("`recent` only works for movie and tv libraries, %s is a %s library", section.Title, section.Type))
+				return false
+			}
+
+			sections = []plex.Directory{section}
+		}
+
+		machineID, err := link.client().GetMachineID()
+
+		if err != nil {
+			fmt.Printf("recent() - could not fetch machine id: %v\n", err)
+			commandList.showError(channelID, "dobby error - could not get machine id from plex server")
+			return false
+		}
+
+		// episodes get grouped by show so ask for more than we show
+		items, err := getRecentlyAdded(link.client(), sections, count*recentEpisodeLines)
+
+		if err != nil {
+			fmt.Printf("recent() - could not fetch recently added: %v\n", err)
+			commandList.showError(channelID, "could not fetch what was added to your plex server")
+			return false
+		}
+
+		groups := groupByShow(items)
+
+		if len(groups) == 0 {
+			commandList.discord.ChannelMessageSend(channelID, "nothing has been added yet")
+			return true
+		}
+
+		if len(groups) > count {
+			groups = groups[:count]
+		}
+
+		for _, group := range groups {
+			embed, poster := groupEmbed(group, machineID)
+
+			if err := sendMediaEmbed(commandList, link.client(), channelID, embed, poster); err != nil {
+				fmt.Printf("recent() - message sent to discord failed: %v\n", err)
+			}
+		}
+
+		return true
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGroupByShow(t *testing.T) {
+	movie := func(key string) mediaItem {
+		return mediaItem{RatingKey: key, Type: "movie"}
+	}
+
+	episode := func(key, show string) mediaItem {
+		return mediaItem{RatingKey: key, Type: "episode", GrandparentRatingKey: show}
+	}
+
+	keys := func(groups [][]mediaItem) [][]string {
+		result := [][]string{}
+
+		for _, group := range groups {
+			ratingKeys := []string{}
+
+			for _, item := range group {
+				ratingKeys = append(ratingKeys, item.RatingKey)
+			}
+
+			result = append(result, ratingKeys)
+		}
+
+		return result
+	}
+
+	tests := []struct {
+		name  string
+		items []mediaItem
+		want  [][]string
+	}{
+		{
+			name:  "nothing",
+			items: nil,
+			want:  [][]string{},
+		},
+		{
+			name:  "movies stay on their own",
+			items: []mediaItem{movie("1"), movie("2")},
+			want:  [][]string{{"1"}, {"2"}},
+		},
+		{
+			name:  "episodes of a show are grouped where the show first shows up",
+			items: []mediaItem{episode("1", "a"), movie("2"), episode("3", "b"), episode("4", "a")},
+			want:  [][]string{{"1", "4"}, {"2"}, {"3"}},
+		},
+		{
+			name:  "episodes without a show stay on their own",
+			items: []mediaItem{episode("1", ""), episode("2", "")},
+			want:  [][]string{{"1"}, {"2"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := keys(groupByShow(test.items))
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("groupByShow() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// reloadConfig re-reads secrets.toml and applies whatever changed without
+// dropping our discord connection. It returns a list of what changed
+func reloadConfig(commandList d, services *clients) ([]string, error) {
+	newConfig, err := readCredentialsTOML(secretsFilepath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	oldConfig := services.getConfig()
+	changes := []string{}
+
+	// the gateway connection is tied to the discord token so we can only use a new one on restart
+	if newConfig.DiscordToken != "" && newConfig.DiscordToken != oldConfig.DiscordToken {
+		changes = append(changes, "discord token (restart dobby to use it)")
+	}
+
+	newConfig.DiscordToken = oldConfig.DiscordToken
+
+	if newConfig.Keyword == "" {
+		newConfig.Keyword = defaultKeyword
+	}
+
+	if newConfig.Keyword != oldConfig.Keyword {
+		changes = append(changes, fmt.Sprintf("keyword `%s` -> `%s`", oldConfig.Keyword, newConfig.Keyword))
+	}
+
+	// keep the bot application owner if no owner is set
+	if newConfig.OwnerID == "" {
+		newConfig.OwnerID = oldConfig.OwnerID
+	} else if newConfig.OwnerID != oldConfig.OwnerID {
+		changes = append(changes, "owner")
+	}
+
+	services.setConfig(newConfig)
+
+	if changed, err := relinkPlex(services.defaultPlex, oldConfig.Plex, newConfig.Plex); err != nil {
+		return changes, err
+	} else if changed {
+		changes = append(changes, "plex server")
+	}
+
+	if newConfig.MultiTenant != oldConfig.MultiTenant {
+		changes = append(changes, fmt.Sprintf("multi-tenant mode %t -> %t", oldConfig.MultiTenant, newConfig.MultiTenant))
+	}
+
+	if newConfig.MultiTenant {
+		oldGuilds := oldConfig.Guilds
+
+		// guilds were never linked while multi-tenant mode was off
+		if !oldConfig.MultiTenant {
+			oldGuilds = nil
+		}
+
+		for guildID, guildCredentials := range newConfig.Guilds {
+			changed, err := relinkPlex(services.getPlexLink(guildID), oldGuilds[guildID], guildCredentials)
+
+			if err != nil {
+				changes = append(changes, fmt.Sprintf("plex server for guild %s failed: %v", guildID, err))
+			} else if changed {
+				changes = append(changes, "plex server for guild "+guildID)
+			}
+		}
+
+		for guildID := range oldGuilds {
+			if _, ok := newConfig.Guilds[guildID]; !ok {
+				relinkPlex(services.getPlexLink(guildID), oldGuilds[guildID], plexCredentials{})
+				changes = append(changes, "removed plex server for guild "+guildID)
+			}
+		}
+	}
+
+	addCommands(commandList, services)
+
+	if len(changes) == 0 {
+		changes = append(changes, "nothing changed")
+	}
+
+	return changes, nil
+}
+
+// relinkPlex points link at newCredentials if they differ from oldCredentials
+func relinkPlex(link *plexLink, oldCredentials, newCredentials plexCredentials) (bool, error) {
+	if oldCredentials == newCredentials {
+		return false, nil
+	}
+
+	if newCredentials.Token == "" {
+		link.setPlexToken("")
+		link.setAuthorized(false)
+
+		return true, nil
+	}
+
+	if newCredentials.Token != oldCredentials.Token {
+		return true, link.link(newCredentials)
+	}
+
+	return true, link.authorize(newCredentials.Host)
+}
+
+// reload re-reads our configuration on the owner's request
+func reload(commandList d, services *clients) func(m *discordgo.Message, args ...string) bool {
+	return func(m *discordgo.Message, args ...string) bool {
+		channelID := m.ChannelID
+
+		changes, err := reloadConfig(commandList, services)
+
+		if err != nil {
+			fmt.Printf("reload() - failed: %v\n", err)
+			commandList.showError(channelID, fmt.Sprintf("reload failed: %v", err))
+			return false
+		}
+
+		commandList.discord.ChannelMessageSend(channelID, "reloaded configuration:\n- "+strings.Join(changes, "\n- "))
+
+		return true
+	}
+}
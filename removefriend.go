@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/jrudio/go-plex-client"
+)
+
+// errNoAccess is returned when a plex user has no access to the server we want to change
+var errNoAccess = errors.New("does not have access to the plex server")
+
+// findSharedServer looks up a user by username or email, ignoring case
+func findSharedServer(shared []sharedServer, usernameOrEmail string) (sharedServer, bool) {
+	for _, user := range shared {
+		if strings.EqualFold(user.Username, usernameOrEmail) || strings.EqualFold(user.Email, usernameOrEmail) {
+			return user, true
+		}
+	}
+
+	return sharedServer{}, false
+}
+
+// removeSharedServer stops sharing our server with the user and removes them as a plex friend
+func removeSharedServer(plexClient *plex.Plex, machineID string, user sharedServer) error {
+	query := fmt.Sprintf("%s/api/servers/%s/shared_servers/%d", plexTVURL, machineID, user.ID)
+
+	resp, err := plexTVRequest(plexClient, "DELETE", query, nil)
+
+	if err != nil {
+		return err
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(resp.Status)
+	}
+
+	// pending invites to an email have no plex account to unfriend
+	if user.UserID == 0 {
+		return nil
+	}
+
+	if _, err := plexClient.RemoveFriend(strconv.Itoa(user.UserID)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// revokeAccess stops sharing the guild's plex server with usernameOrEmail
+func revokeAccess(services *clients, guildID, usernameOrEmail string) (sharedServer, error) {
+	link := services.getPlexLink(guildID)
+
+	if !link.authorized() {
+		return sharedServer{}, errors.New("dobby is not linked to a plex server")
+	}
+
+	machineID, err := link.client().GetMachineID()
+
+	if err != nil {
+		return sharedServer{}, err
+	}
+
+	shared, err := getSharedServers(link.client(), machineID)
+
+	if err != nil {
+		return sharedServer{}, err
+	}
+
+	user, ok := findSharedServer(shared, usernameOrEmail)
+
+	if !ok {
+		return sharedServer{}, fmt.Errorf("`%s` %w", usernameOrEmail, errNoAccess)
+	}
+
+	return user, removeSharedServer(link.client(), machineID, user)
+}
+
+// removeFriend revokes a user's access to the plex server
+//
+// command: remove-friend <username|email|@member> [--notify] [--reason text]
+func removeFriend(commandList d, services *clients) func(m *discordgo.Message, args ...string) bool {
+	return func(m *discordgo.Message, args ...string) bool {
+		channelID := m.ChannelID
+		guildID := commandList.getGuildID(channelID)
+		link := services.getPlexLink(guildID)
+
+		if !link.authorized() {
+			commandList.showError(channelID, "dobby is not linked to a plex server -- run `link`")
+			return false
+		}
+
+		args, options, err := parseOptions(args, optionList{"notify": false, "reason": true})
+
+		if err != nil {
+			commandList.showError(channelID, err.Error())
+			return false
+		}
+
+		if len(args) < 1 {
+			commandList.showError(channelID, "a username, an email or a @mention is required")
+			return false
+		}
+
+		target, discordUserID, err := resolvePlexUser(services, guildID, args[0])
+
+		if err != nil {
+			commandList.showError(channelID, err.Error())
+			return false
+		}
+
+		machineID, err := link.client().GetMachineID()
+
+		if err != nil {
+			fmt.Printf("removeFriend() - could not fetch machine id: %v\n", err)
+			commandList.showError(channelID, "dobby error - could not get machine id from plex server")
+			return false
+		}
+
+		shared, err := getSharedServers(link.client(), machineID)
+
+		if err != nil {
+			fmt.Printf("removeFriend() - could not fetch shared users: %v\n", err)
+			commandList.showError(channelID, "could not fetch the users of your plex server")
+			return false
+		}
+
+		user, ok := findSharedServer(shared, target)
+
+		if !ok {
+			commandList.showError(channelID, fmt.Sprintf("`%s` does not have access to your plex server", target))
+			return false
+		}
+
+		// let the member know even when they were named by their plex account
+		if account, ok := findAccountByPlex(services, guildID, user); ok && discordUserID == "" {
+			discordUserID = account.DiscordID
+		}
+
+		_, notify := options["notify"]
+		reason := options["reason"]
+
+		askConfirmation(commandList, m, fmt.Sprintf("remove **%s** from your plex server?", user.name()), func() {
+			if err := removeSharedServer(link.client(), machineID, user); err != nil {
+				fmt.Printf("removeFriend() - failed: %v\n", err)
+				commandList.showError(channelID, fmt.Sprintf("could not remove %s: %v", user.name(), err))
+				return
+			}
+
+			audit(guildID, m.Author.ID, "remove-friend", fmt.Sprintf("user=%s email=%s reason=%q", user.Username, user.Email, reason))
+
+			message := fmt.Sprintf("removed %s from our Plex server", user.name())
+
+			if notify && discordUserID != "" {
+				notice := "Your access to our Plex server has been removed"
+
+				if reason != "" {
+					notice += ": " + reason
+				}
+
+				if err := commandList.sendDM(discordUserID, notice); err != nil {
+					fmt.Printf("removeFriend() - could not DM %s: %v\n", discordUserID, err)
+					message += " (could not DM them)"
+				} else {
+					message += " and let them know"
+				}
+			}
+
+			commandList.discord.ChannelMessageSend(channelID, message)
+		})
+
+		return true
+	}
+}
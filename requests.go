@@ -0,0 +1,389 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	approveEmoji = "✅"
+	denyEmoji    = "❌"
+
+	requestPending  = "pending"
+	requestApproved = "approved"
+	requestDenied   = "denied"
+)
+
+// accessRequest is a member asking admins for access to the plex server
+type accessRequest struct {
+	ID              int       `toml:"id"`
+	GuildID         string    `toml:"guildID"`
+	ChannelID       string    `toml:"channelID"`
+	RequesterID     string    `toml:"requesterID"`
+	UsernameOrEmail string    `toml:"usernameOrEmail"`
+	RequestedAt     time.Time `toml:"requestedAt"`
+	Status          string    `toml:"status"`
+	// AdminChannelID and AdminMessageID point to the message admins react to
+	AdminChannelID string    `toml:"adminChannelID"`
+	AdminMessageID string    `toml:"adminMessageID"`
+	DecidedBy      string    `toml:"decidedBy"`
+	DecidedAt      time.Time `toml:"decidedAt"`
+	Reason         string    `toml:"reason"`
+}
+
+// findAccessRequest returns the request matching fn
+func findAccessRequest(services *clients, fn func(request accessRequest) bool) (accessRequest, bool) {
+	var found accessRequest
+	ok := false
+
+	services.data.view(func(data dobbyData) {
+		for _, request := range data.AccessRequests {
+			if fn(request) {
+				found = request
+				ok = true
+				return
+			}
+		}
+	})
+
+	return found, ok
+}
+
+// saveAccessRequest replaces the stored request with the same id
+func saveAccessRequest(services *clients, request accessRequest) error {
+	return services.data.update(func(data *dobbyData) {
+		for i := range data.AccessRequests {
+			if data.AccessRequests[i].ID == request.ID {
+				data.AccessRequests[i] = request
+				return
+			}
+		}
+	})
+}
+
+// decideAccessRequest moves a pending request to status and returns it
+//
+// the check and the change happen in one update so a request is only ever decided once,
+// even when two admins click at the same time
+func decideAccessRequest(services *clients, id int, status, adminID, reason string) (accessRequest, error) {
+	var decided accessRequest
+	var decideErr error
+
+	err := services.data.update(func(data *dobbyData) {
+		for i := range data.AccessRequests {
+			request := &data.AccessRequests[i]
+
+			if request.ID != id {
+				continue
+			}
+
+			if request.Status != requestPending {
+				decideErr = fmt.Errorf("request #%d was already %s", request.ID, request.Status)
+				return
+			}
+
+			request.Status = status
+			request.DecidedBy = adminID
+			request.DecidedAt = time.Now()
+			request.Reason = reason
+			decided = *request
+
+			return
+		}
+
+		decideErr = fmt.Errorf("there is no request #%d", id)
+	})
+
+	if err != nil {
+		return decided, err
+	}
+
+	return decided, decideErr
+}
+
+// reopenAccessRequest puts a request back to pending when acting on a decision failed
+func reopenAccessRequest(services *clients, request accessRequest) {
+	request.Status = requestPending
+	request.DecidedBy = ""
+	request.DecidedAt = time.Time{}
+	request.Reason = ""
+
+	if err := saveAccessRequest(services, request); err != nil {
+		fmt.Printf("reopenAccessRequest() - could not save request #%d: %v\n", request.ID, err)
+	}
+}
+
+// requestAccess posts a member's request for access to the admin channel
+//
+// command: request-access <email|username>
+func requestAccess(commandList d, services *clients) func(m *discordgo.Message, args ...string) bool {
+	return func(m *discordgo.Message, args ...string) bool {
+		channelID := m.ChannelID
+		guildID := commandList.getGuildID(channelID)
+
+		if len(args) < 1 {
+			commandList.showError(channelID, "an email or a plex username is required")
+			return false
+		}
+
+		adminChannelID := services.getGuildSettings(guildID).AdminChannelID
+
+		if adminChannelID == "" {
+			commandList.showError(channelID, "admins have not set up an admin channel yet -- `settings admin-channel #channel`")
+			return false
+		}
+
+		_, hasPending := findAccessRequest(services, func(request accessRequest) bool {
+			return request.GuildID == guildID && request.RequesterID == m.Author.ID && request.Status == requestPending
+		})
+
+		if hasPending {
+			commandList.showError(channelID, "you already have a request waiting for an admin")
+			return false
+		}
+
+		request := accessRequest{
+			GuildID:         guildID,
+			ChannelID:       channelID,
+			RequesterID:     m.Author.ID,
+			UsernameOrEmail: args[0],
+			RequestedAt:     time.Now(),
+			Status:          requestPending,
+			AdminChannelID:  adminChannelID,
+		}
+
+		err := services.data.update(func(data *dobbyData) {
+			data.NextRequestID++
+			request.ID = data.NextRequestID
+		})
+
+		if err != nil {
+			fmt.Printf("requestAccess() - could not save request: %v\n", err)
+			commandList.showError(channelID, "`internal error - could not save your request`")
+			return false
+		}
+
+		adminMessage, err := commandList.discord.ChannelMessageSend(adminChannelID, fmt.Sprintf(
+			"Access request #%d: <@%s> would like access to our Plex server as `%s`\nreact %s to approve or %s to deny (or `requests approve %d` / `requests deny %d [reason]`)",
+			request.ID, request.RequesterID, request.UsernameOrEmail, approveEmoji, denyEmoji, request.ID, request.ID))
+
+		if err != nil {
+			fmt.Printf("requestAccess() - could not post to admin channel: %v\n", err)
+			commandList.showError(channelID, "could not reach the admins, try again later")
+			return false
+		}
+
+		request.AdminMessageID = adminMessage.ID
+
+		commandList.discord.MessageReactionAdd(adminChannelID, adminMessage.ID, approveEmoji)
+		commandList.discord.MessageReactionAdd(adminChannelID, adminMessage.ID, denyEmoji)
+
+		err = services.data.update(func(data *dobbyData) {
+			data.AccessRequests = append(data.AccessRequests, request)
+		})
+
+		if err != nil {
+			fmt.Printf("requestAccess() - could not save request: %v\n", err)
+			commandList.showError(channelID, "`internal error - could not save your request`")
+			return false
+		}
+
+		commandList.discord.ChannelMessageSend(channelID, fmt.Sprintf("request #%d sent -- an admin will look at it soon", request.ID))
+
+		return true
+	}
+}
+
+// approveAccessRequest invites the requester and lets them know
+func approveAccessRequest(commandList d, services *clients, request accessRequest, adminID string) error {
+	link := services.getPlexLink(request.GuildID)
+
+	if !link.authorized() {
+		return errors.New("dobby is not linked to a plex server -- run `link`")
+	}
+
+	request, err := decideAccessRequest(services, request.ID, requestApproved, adminID, "")
+
+	if err != nil {
+		return err
+	}
+
+	if err := sendInvite(link, request.UsernameOrEmail, sharingSettings{}); err != nil {
+		reopenAccessRequest(services, request)
+		return err
+	}
+
+	trackInvite(services, trackedInvite{
+		GuildID:         request.GuildID,
+		ChannelID:       request.ChannelID,
+		InvitedBy:       adminID,
+		UsernameOrEmail: request.UsernameOrEmail,
+		InvitedAt:       time.Now(),
+	})
+
+	err = linkAccount(services, linkedAccount{
+		GuildID:   request.GuildID,
+		DiscordID: request.RequesterID,
+		PlexUser:  request.UsernameOrEmail,
+		LinkedBy:  adminID,
+		LinkedAt:  time.Now(),
+	})
+
+	if err != nil {
+		fmt.Printf("approveAccessRequest() - could not link account: %v\n", err)
+	}
+
+	audit(request.GuildID, adminID, "approve-access", fmt.Sprintf("request=%d requester=%s user=%s", request.ID, request.RequesterID, request.UsernameOrEmail))
+
+	commandList.discord.ChannelMessageEdit(request.AdminChannelID, request.AdminMessageID, fmt.Sprintf(
+		"Access request #%d from <@%s> as `%s` was approved by <@%s>",
+		request.ID, request.RequesterID, request.UsernameOrEmail, adminID))
+
+	commandList.discord.ChannelMessageSend(request.ChannelID, fmt.Sprintf(
+		"<@%s> your access request was approved! Check `%s` for an invite to our Plex server",
+		request.RequesterID, request.UsernameOrEmail))
+
+	return nil
+}
+
+// denyAccessRequest closes the request and tells the requester
+func denyAccessRequest(commandList d, services *clients, request accessRequest, adminID, reason string) error {
+	request, err := decideAccessRequest(services, request.ID, requestDenied, adminID, reason)
+
+	if err != nil {
+		return err
+	}
+
+	audit(request.GuildID, adminID, "deny-access", fmt.Sprintf("request=%d requester=%s user=%s reason=%q", request.ID, request.RequesterID, request.UsernameOrEmail, reason))
+
+	commandList.discord.ChannelMessageEdit(request.AdminChannelID, request.AdminMessageID, fmt.Sprintf(
+		"Access request #%d from <@%s> as `%s` was denied by <@%s>",
+		request.ID, request.RequesterID, request.UsernameOrEmail, adminID))
+
+	notice := "Your request for access to our Plex server was denied"
+
+	if reason != "" {
+		notice += ": " + reason
+	}
+
+	// fall back to the channel they asked in if their DMs are closed
+	if err := commandList.sendDM(request.RequesterID, notice); err != nil {
+		commandList.discord.ChannelMessageSend(request.ChannelID, "<@"+request.RequesterID+"> "+notice)
+	}
+
+	return nil
+}
+
+// accessRequests lists, approves or denies access requests
+//
+// command: requests | requests approve <id> | requests deny <id> [reason]
+func accessRequests(commandList d, services *clients) func(m *discordgo.Message, args ...string) bool {
+	return func(m *discordgo.Message, args ...string) bool {
+		channelID := m.ChannelID
+		guildID := commandList.getGuildID(channelID)
+
+		if len(args) < 1 {
+			message := ""
+
+			services.data.view(func(data dobbyData) {
+				for _, request := range data.AccessRequests {
+					if request.GuildID != guildID || request.Status != requestPending {
+						continue
+					}
+
+					message += fmt.Sprintf("#%d <@%s> as `%s` - %s\n",
+						request.ID, request.RequesterID, request.UsernameOrEmail, formatAge(request.RequestedAt))
+				}
+			})
+
+			if message == "" {
+				message = "there are no pending access requests"
+			} else {
+				message = "Pending access requests:\n" + message
+			}
+
+			commandList.discord.ChannelMessageSend(channelID, message)
+
+			return true
+		}
+
+		if len(args) < 2 || (args[0] != "approve" && args[0] != "deny") {
+			commandList.showError(channelID, "usage: `requests`, `requests approve <id>` or `requests deny <id> [reason]`")
+			return false
+		}
+
+		id, err := strconv.Atoi(strings.TrimPrefix(args[1], "#"))
+
+		if err != nil {
+			commandList.showError(channelID, fmt.Sprintf("`%s` is not a request number", args[1]))
+			return false
+		}
+
+		request, ok := findAccessRequest(services, func(request accessRequest) bool {
+			return request.ID == id && request.GuildID == guildID
+		})
+
+		if !ok {
+			commandList.showError(channelID, fmt.Sprintf("there is no request #%d", id))
+			return false
+		}
+
+		if args[0] == "approve" {
+			err = approveAccessRequest(commandList, services, request, m.Author.ID)
+		} else {
+			err = denyAccessRequest(commandList, services, request, m.Author.ID, strings.Join(args[2:], " "))
+		}
+
+		if err != nil {
+			commandList.showError(channelID, err.Error())
+			return false
+		}
+
+		commandList.discord.ChannelMessageSend(channelID, fmt.Sprintf("request #%d %sd", id, args[0]))
+
+		return true
+	}
+}
+
+// onReactionAdd lets admins approve or deny access requests by reacting to them
+func onReactionAdd(commandList d, services *clients) func(s *discordgo.Session, r *discordgo.MessageReactionAdd) {
+	return func(s *discordgo.Session, r *discordgo.MessageReactionAdd) {
+		if r.UserID == s.State.User.ID {
+			return
+		}
+
+		if r.Emoji.Name != approveEmoji && r.Emoji.Name != denyEmoji {
+			return
+		}
+
+		request, ok := findAccessRequest(services, func(request accessRequest) bool {
+			return request.AdminMessageID == r.MessageID
+		})
+
+		if !ok || request.Status != requestPending {
+			return
+		}
+
+		if !isAdmin(commandList, services, r.UserID, r.ChannelID) {
+			return
+		}
+
+		var err error
+
+		if r.Emoji.Name == approveEmoji {
+			err = approveAccessRequest(commandList, services, request, r.UserID)
+		} else {
+			err = denyAccessRequest(commandList, services, request, r.UserID, "")
+		}
+
+		if err != nil {
+			fmt.Printf("onReactionAdd() - request #%d: %v\n", request.ID, err)
+			commandList.showError(r.ChannelID, fmt.Sprintf("request #%d: %v", request.ID, err))
+		}
+	}
+}
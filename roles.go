@@ -0,0 +1,589 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/jrudio/go-plex-client"
+)
+
+// roleSyncInterval is how often every member's plex libraries are checked against their roles
+const roleSyncInterval = time.Hour
+
+// roleLibraries are the plex libraries shared with members of a discord role
+type roleLibraries struct {
+	RoleID string `toml:"roleID"`
+	// All shares every library, including ones added later
+	All       bool     `toml:"all"`
+	Libraries []string `toml:"libraries"`
+}
+
+// describe lists the libraries of a role for a discord message
+func (r roleLibraries) describe() string {
+	if r.All {
+		return "all libraries"
+	}
+
+	return strings.Join(r.Libraries, ", ")
+}
+
+// roleMember remembers the mapped roles a member's libraries were last synced from
+type roleMember struct {
+	GuildID   string   `toml:"guildID"`
+	DiscordID string   `toml:"discordID"`
+	Roles     []string `toml:"roles"`
+}
+
+// mappedRoles returns the member's roles that are mapped to libraries, sorted
+func mappedRoles(mappings []roleLibraries, memberRoles []string) []string {
+	mapped := []string{}
+
+	for _, mapping := range mappings {
+		if containsFold(memberRoles, mapping.RoleID) {
+			mapped = append(mapped, mapping.RoleID)
+		}
+	}
+
+	sort.Strings(mapped)
+
+	return mapped
+}
+
+// syncedRoles returns the mapped roles a member was last synced from
+//
+// ok is false when role sync has never managed the member's libraries
+func syncedRoles(services *clients, guildID, discordID string) (roles []string, ok bool) {
+	services.data.view(func(data dobbyData) {
+		for _, member := range data.RoleMembers {
+			if member.GuildID == guildID && member.DiscordID == discordID {
+				roles = member.Roles
+				ok = true
+				return
+			}
+		}
+	})
+
+	return roles, ok
+}
+
+// saveSyncedRoles remembers the mapped roles a member was synced from. No roles forgets the member
+func saveSyncedRoles(services *clients, guildID, discordID string, roles []string) error {
+	return services.data.update(func(data *dobbyData) {
+		members := data.RoleMembers[:0]
+
+		for _, member := range data.RoleMembers {
+			if member.GuildID != guildID || member.DiscordID != discordID {
+				members = append(members, member)
+			}
+		}
+
+		if len(roles) > 0 {
+			members = append(members, roleMember{GuildID: guildID, DiscordID: discordID, Roles: roles})
+		}
+
+		data.RoleMembers = members
+	})
+}
+
+// roleSync holds what we need from plex to reconcile the members of one guild
+type roleSync struct {
+	link      *plexLink
+	machineID string
+	sections  []plex.ServerSections
+	shared    []sharedServer
+	roles     []roleLibraries
+}
+
+// newRoleSync fetches the libraries and users of the guild's plex server
+func newRoleSync(services *clients, guildID string) (*roleSync, error) {
+	link := services.getPlexLink(guildID)
+
+	if !link.authorized() {
+		return nil, errors.New("dobby is not linked to a plex server")
+	}
+
+	machineID, err := link.client().GetMachineID()
+
+	if err != nil {
+		return nil, err
+	}
+
+	sections, err := link.client().GetSections(machineID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	shared, err := getSharedServers(link.client(), machineID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &roleSync{
+		link:      link,
+		machineID: machineID,
+		sections:  sections,
+		shared:    shared,
+		roles:     services.getGuildSettings(guildID).Roles,
+	}, nil
+}
+
+// wantedSections returns the library sections members of the mapped roles should have
+//
+// a mapping naming a library the server doesn't have is an error rather than
+// a reason to take that library away
+func (r *roleSync) wantedSections(mapped []string) ([]plex.ServerSections, error) {
+	wanted := []string{}
+	all := false
+
+	for _, mapping := range r.roles {
+		if !containsFold(mapped, mapping.RoleID) {
+			continue
+		}
+
+		if _, err := matchLibraries(r.sections, mapping.Libraries); err != nil {
+			return nil, fmt.Errorf("<@&%s>: %v", mapping.RoleID, err)
+		}
+
+		all = all || mapping.All
+		wanted = append(wanted, mapping.Libraries...)
+	}
+
+	sections := []plex.ServerSections{}
+
+	for _, section := range r.sections {
+		if all || containsFold(wanted, strings.TrimSpace(section.Title)) {
+			sections = append(sections, section)
+		}
+	}
+
+	return sections, nil
+}
+
+// syncMember shares the libraries of a member's roles with their linked plex account
+// and removes the access of members that lost every mapped role
+//
+// it returns a description of what changed, empty when nothing did
+func (r *roleSync) syncMember(services *clients, guildID, discordID string, memberRoles []string) (string, error) {
+	mapped := mappedRoles(r.roles, memberRoles)
+	_, wasManaged := syncedRoles(services, guildID, discordID)
+
+	if len(mapped) == 0 && !wasManaged {
+		return "", nil
+	}
+
+	account, ok := findAccountByDiscord(services, guildID, discordID)
+
+	if !ok {
+		return "", nil
+	}
+
+	user, ok := findSharedServer(r.shared, account.PlexUser)
+
+	if !ok {
+		if len(mapped) == 0 {
+			return "", saveSyncedRoles(services, guildID, discordID, nil)
+		}
+
+		return "", nil
+	}
+
+	if len(mapped) == 0 {
+		if err := removeSharedServer(r.link.client(), r.machineID, user); err != nil {
+			return "", err
+		}
+
+		if err := saveSyncedRoles(services, guildID, discordID, nil); err != nil {
+			fmt.Printf("syncMember() - could not save synced roles: %v\n", err)
+		}
+
+		return fmt.Sprintf("<@%s> (`%s`): no mapped role left, removed their access", discordID, user.name()), nil
+	}
+
+	sections, err := r.wantedSections(mapped)
+
+	if err != nil {
+		return "", err
+	}
+
+	current := user.libraries()
+	wanted := make([]string, len(sections))
+	ids := make([]int, len(sections))
+
+	for i, section := range sections {
+		wanted[i] = section.Title
+		ids[i] = section.ID
+	}
+
+	changed := !sameLibraries(current, wanted)
+
+	if changed {
+		if err := updateSharedLibraries(r.link.client(), r.machineID, user, ids); err != nil {
+			return "", err
+		}
+	}
+
+	if err := saveSyncedRoles(services, guildID, discordID, mapped); err != nil {
+		fmt.Printf("syncMember() - could not save synced roles: %v\n", err)
+	}
+
+	if !changed {
+		return "", nil
+	}
+
+	return fmt.Sprintf("<@%s> (`%s`): %s → %s", discordID, user.name(), describeLibraries(current), describeLibraries(wanted)), nil
+}
+
+// sameLibraries compares library titles ignoring order and case
+func sameLibraries(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	trimmed := make([]string, len(b))
+
+	for i, library := range b {
+		trimmed[i] = strings.TrimSpace(library)
+	}
+
+	for _, library := range a {
+		if !containsFold(trimmed, strings.TrimSpace(library)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// describeLibraries joins library titles for a discord message
+func describeLibraries(libraries []string) string {
+	if len(libraries) == 0 {
+		return "none"
+	}
+
+	return strings.Join(libraries, ", ")
+}
+
+// updateSharedLibraries changes which libraries are shared with a plex user
+func updateSharedLibraries(plexClient *plex.Plex, machineID string, user sharedServer, libraryIDs []int) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"server_id": machineID,
+		"shared_server": map[string]interface{}{
+			"library_section_ids": libraryIDs,
+			"invited_id":          user.UserID,
+		},
+	})
+
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf("%s/api/servers/%s/shared_servers/%d", plexTVURL, machineID, user.ID)
+
+	resp, err := plexTVRequest(plexClient, "PUT", query, body)
+
+	if err != nil {
+		return err
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(resp.Status)
+	}
+
+	return nil
+}
+
+// getAllMembers pages through every member of a guild
+func getAllMembers(discord *discordgo.Session, guildID string) ([]*discordgo.Member, error) {
+	members := []*discordgo.Member{}
+	after := ""
+
+	for {
+		page, err := discord.GuildMembers(guildID, after, 1000)
+
+		if err != nil {
+			return nil, err
+		}
+
+		members = append(members, page...)
+
+		if len(page) < 1000 {
+			return members, nil
+		}
+
+		after = page[len(page)-1].User.ID
+	}
+}
+
+// syncRoles reconciles the plex libraries of every member of a guild with their roles
+// and returns what had drifted
+func syncRoles(commandList d, services *clients, guildID string) ([]string, error) {
+	syncer, err := newRoleSync(services, guildID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	members, err := getAllMembers(commandList.discord, guildID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []string{}
+
+	for _, member := range members {
+		change, err := syncer.syncMember(services, guildID, member.User.ID, member.Roles)
+
+		if err != nil {
+			fmt.Printf("syncRoles() - could not update %s: %v\n", member.User.ID, err)
+			changes = append(changes, fmt.Sprintf("<@%s>: could not update libraries: %v", member.User.ID, err))
+			continue
+		}
+
+		if change != "" {
+			changes = append(changes, change)
+		}
+	}
+
+	return changes, nil
+}
+
+// watchRoles periodically syncs every guild that maps roles to libraries and reports drift to admins
+func watchRoles(commandList d, services *clients) {
+	for {
+		time.Sleep(roleSyncInterval)
+
+		guildIDs := []string{}
+
+		services.data.view(func(data dobbyData) {
+			for guildID, settings := range data.Guilds {
+				if len(settings.Roles) > 0 {
+					guildIDs = append(guildIDs, guildID)
+				}
+			}
+		})
+
+		for _, guildID := range guildIDs {
+			changes, err := syncRoles(commandList, services, guildID)
+
+			if err != nil {
+				fmt.Printf("watchRoles() - could not sync guild %s: %v\n", guildID, err)
+				continue
+			}
+
+			if len(changes) > 0 {
+				alertAdmins(commandList, services, guildID, "Role sync found libraries that did not match roles and fixed them:\n"+strings.Join(changes, "\n"))
+			}
+		}
+	}
+}
+
+// onMemberUpdate syncs a member's plex libraries when their roles change
+func onMemberUpdate(commandList d, services *clients) func(s *discordgo.Session, event *discordgo.GuildMemberUpdate) {
+	return func(s *discordgo.Session, event *discordgo.GuildMemberUpdate) {
+		if event.Member == nil || event.User == nil {
+			return
+		}
+
+		mappings := services.getGuildSettings(event.GuildID).Roles
+
+		if len(mappings) == 0 {
+			return
+		}
+
+		if _, ok := findAccountByDiscord(services, event.GuildID, event.User.ID); !ok {
+			return
+		}
+
+		// member updates also fire for nicknames and avatars, only sync when the mapped roles changed
+		mapped := mappedRoles(mappings, event.Roles)
+		previous, wasManaged := syncedRoles(services, event.GuildID, event.User.ID)
+
+		if len(mapped) == 0 && !wasManaged {
+			return
+		}
+
+		if wasManaged && strings.Join(mapped, ",") == strings.Join(previous, ",") {
+			return
+		}
+
+		syncer, err := newRoleSync(services, event.GuildID)
+
+		if err != nil {
+			fmt.Printf("onMemberUpdate() - could not fetch plex server: %v\n", err)
+			return
+		}
+
+		change, err := syncer.syncMember(services, event.GuildID, event.User.ID, event.Roles)
+
+		if err != nil {
+			fmt.Printf("onMemberUpdate() - could not update %s: %v\n", event.User.ID, err)
+			alertAdmins(commandList, services, event.GuildID, fmt.Sprintf("roles of <@%s> changed but their plex libraries could not be updated: %v", event.User.ID, err))
+			return
+		}
+
+		if change != "" {
+			audit(event.GuildID, s.State.User.ID, "role-sync", change)
+			alertAdmins(commandList, services, event.GuildID, "roles changed, updated plex libraries of "+change)
+		}
+	}
+}
+
+// roles maps discord roles to plex libraries
+//
+// command: roles | roles set @role <Movies,TV|all> | roles remove @role | roles sync
+func roles(commandList d, services *clients) func(m *discordgo.Message, args ...string) bool {
+	return func(m *discordgo.Message, args ...string) bool {
+		channelID := m.ChannelID
+		guildID := commandList.getGuildID(channelID)
+
+		if guildID == "" {
+			commandList.showError(channelID, "roles can only be managed from a server channel")
+			return false
+		}
+
+		if len(args) < 1 {
+			mappings := services.getGuildSettings(guildID).Roles
+
+			if len(mappings) == 0 {
+				commandList.discord.ChannelMessageSend(channelID, "no roles are mapped to libraries -- `roles set @role Movies,TV`")
+				return true
+			}
+
+			lines := make([]string, len(mappings))
+
+			for i, mapping := range mappings {
+				lines[i] = fmt.Sprintf("<@&%s>: %s", mapping.RoleID, mapping.describe())
+			}
+
+			sort.Strings(lines)
+
+			commandList.discord.ChannelMessageSend(channelID, "Role libraries:\n"+strings.Join(lines, "\n"))
+
+			return true
+		}
+
+		switch args[0] {
+		case "sync":
+			changes, err := syncRoles(commandList, services, guildID)
+
+			if err != nil {
+				fmt.Printf("roles() - sync failed: %v\n", err)
+				commandList.showError(channelID, fmt.Sprintf("could not sync roles: %v", err))
+				return false
+			}
+
+			if len(changes) == 0 {
+				commandList.discord.ChannelMessageSend(channelID, "everyone's libraries already match their roles")
+				return true
+			}
+
+			audit(guildID, m.Author.ID, "role-sync", strings.Join(changes, "; "))
+
+			if err := commandList.sendLongMessage(channelID, "updated plex libraries:\n"+strings.Join(changes, "\n")); err != nil {
+				fmt.Printf("roles() - message sent to discord failed: %v\n", err)
+			}
+
+			return true
+
+		case "set", "remove":
+		default:
+			commandList.showError(channelID, "usage: `roles`, `roles set @role <Movies,TV|all>`, `roles remove @role` or `roles sync`")
+			return false
+		}
+
+		if len(args) < 2 {
+			commandList.showError(channelID, "a @role is required")
+			return false
+		}
+
+		roleID, ok := parseRoleMention(args[1])
+
+		if !ok {
+			commandList.showError(channelID, fmt.Sprintf("`%s` is not a role -- use `@role`", args[1]))
+			return false
+		}
+
+		mapping := roleLibraries{RoleID: roleID}
+
+		if args[0] == "set" {
+			if len(args) < 3 {
+				commandList.showError(channelID, "libraries are required, like `Movies,TV` or `all`")
+				return false
+			}
+
+			libraries := strings.Join(args[2:], " ")
+
+			if strings.EqualFold(libraries, "all") {
+				mapping.All = true
+			} else {
+				mapping.Libraries = splitLibraries(libraries)
+			}
+
+			// catch typos now rather than on the next sync
+			if !mapping.All {
+				link := services.getPlexLink(guildID)
+
+				if !link.authorized() {
+					commandList.showError(channelID, "dobby is not linked to a plex server -- run `link`")
+					return false
+				}
+
+				machineID, err := link.client().GetMachineID()
+
+				if err != nil {
+					fmt.Printf("roles() - could not fetch machine id: %v\n", err)
+					commandList.showError(channelID, "dobby error - could not get machine id from plex server")
+					return false
+				}
+
+				if _, err := resolveLibraries(link.client(), machineID, mapping.Libraries); err != nil {
+					commandList.showError(channelID, err.Error())
+					return false
+				}
+			}
+		}
+
+		err := services.updateGuildSettings(guildID, func(settings *guildSettings) {
+			mappings := settings.Roles[:0]
+
+			for _, existing := range settings.Roles {
+				if existing.RoleID != roleID {
+					mappings = append(mappings, existing)
+				}
+			}
+
+			if args[0] == "set" {
+				mappings = append(mappings, mapping)
+			}
+
+			settings.Roles = mappings
+		})
+
+		if err != nil {
+			fmt.Printf("roles() - could not save settings: %v\n", err)
+			commandList.showError(channelID, "`internal error - could not save settings`")
+			return false
+		}
+
+		if args[0] == "set" {
+			audit(guildID, m.Author.ID, "role-libraries", fmt.Sprintf("role=%s libraries=%s", roleID, mapping.describe()))
+			commandList.discord.ChannelMessageSend(channelID, fmt.Sprintf("members of <@&%s> get %s -- run `roles sync` to apply it to everyone now", roleID, mapping.describe()))
+		} else {
+			audit(guildID, m.Author.ID, "role-libraries", fmt.Sprintf("role=%s removed", roleID))
+			commandList.discord.ChannelMessageSend(channelID, fmt.Sprintf("<@&%s> no longer gives plex libraries -- members left without a mapped role lose their access on the next sync", roleID))
+		}
+
+		return true
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	defaultSearchLimit = 10
+	// maxSearchLimit keeps the results inside the 2048 characters of an embed description
+	maxSearchLimit = 10
+	// hubSearchLimit is how many items plex returns per hub, more than we show so --type can filter them
+	hubSearchLimit = 25
+)
+
+// searchTypes are the --type filters search understands
+var searchTypes = []string{"movie", "show", "episode", "artist"}
+
+// search searches the libraries of the plex media server
+//
+// command: search <title> [--type movie|show|episode|artist] [--library Movies] [--limit 10]
+func search(commandList d, services *clients) func(m *discordgo.Message, args ...string) bool {
+	return func(m *discordgo.Message, args ...string) bool {
+		channelID := m.ChannelID
+		link := services.getPlexLink(commandList.getGuildID(channelID))
+
+		if !link.authorized() {
+			commandList.showError(channelID, "dobby is not linked to a plex server -- run `link`")
+			return false
+		}
+
+		args, options, err := parseOptions(args, optionList{"type": true, "library": true, "limit": true})
+
+		if err != nil {
+			commandList.showError(channelID, err.Error())
+			return false
+		}
+
+		if len(args) < 1 {
+			commandList.showError(channelID, "usage: `search <title> [--type movie|show|episode|artist] [--library Movies] [--limit 10]`")
+			return false
+		}
+
+		title := strings.Join(args, " ")
+
+		mediaType := strings.ToLower(options["type"])
+
+		if mediaType != "" && !containsFold(searchTypes, mediaType) {
+			commandList.showError(channelID, fmt.Sprintf("`--type` should be one of %s", strings.Join(searchTypes, ", ")))
+			return false
+		}
+
+		limit := defaultSearchLimit
+
+		if value, ok := options["limit"]; ok {
+			var err error
+
+			if limit, err = strconv.Atoi(value); err != nil || limit < 1 || limit > maxSearchLimit {
+				commandList.showError(channelID, fmt.Sprintf("`--limit` should be a number from 1 to %d", maxSearchLimit))
+				return false
+			}
+		}
+
+		query := "/hubs/search?query=" + url.QueryEscape(title) + "&limit=" + strconv.Itoa(hubSearchLimit)
+
+		if library, ok := options["library"]; ok {
+			sections, err := getLibrarySections(link.client())
+
+			if err != nil {
+				fmt.Printf("search() - could not fetch libraries: %v\n", err)
+				commandList.showError(channelID, "could not fetch the libraries of your plex server")
+				return false
+			}
+
+			section, err := findLibrarySection(sections, library)
+
+			if err != nil {
+				commandList.showError(channelID, err.Error())
+				return false
+			}
+
+			query += "&sectionId=" + section.Key
+		}
+
+		var result mediaContainer
+
+		if err := pmsGet(link.client(), query, &result); err != nil {
+			fmt.Printf("search() - could not search plex: %v\n", err)
+			commandList.showError(channelID, "could not search your plex server")
+			return false
+		}
+
+		machineID, err := link.client().GetMachineID()
+
+		if err != nil {
+			fmt.Printf("search() - could not fetch machine id: %v\n", err)
+			commandList.showError(channelID, "dobby error - could not get machine id from plex server")
+			return false
+		}
+
+		lines := []string{}
+
+		for _, hub := range result.MediaContainer.Hub {
+			for _, item := range hub.Metadata {
+				if len(lines) == limit {
+					break
+				}
+
+				if !containsFold(searchTypes, item.Type) || (mediaType != "" && item.Type != mediaType) {
+					continue
+				}
+
+				line := fmt.Sprintf("[%s](%s)", item.name(), item.link(machineID))
+
+				if item.LibrarySectionTitle != "" {
+					line += " · " + item.LibrarySectionTitle
+				}
+
+				if resolution := item.resolution(); resolution != "" {
+					line += " · " + resolution
+				}
+
+				lines = append(lines, line)
+			}
+		}
+
+		if len(lines) == 0 {
+			commandList.discord.ChannelMessageSend(channelID, fmt.Sprintf("nothing on our Plex server matches `%s`", title))
+			return true
+		}
+
+		_, err = commandList.discord.ChannelMessageSendEmbed(channelID, &discordgo.MessageEmbed{
+			Title:       fmt.Sprintf("Search results for \"%s\"", title),
+			Description: strings.Join(lines, "\n"),
+		})
+
+		if err != nil {
+			fmt.Printf("search() - message sent to discord failed: %v\n", err)
+		}
+
+		return true
+	}
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// secrets.go handles reading and writing secrets.toml without clobbering
+// anything the user put in there by hand
+
+const (
+	// encryptedPrefix marks a token that was encrypted at rest
+	encryptedPrefix = "enc:"
+	saltLen         = 16
+	passphraseEnv   = "DOBBY_PASSPHRASE"
+)
+
+var (
+	// keyFilepath is a file whose contents are used as the passphrase for encrypting tokens
+	keyFilepath string
+
+	errNoPassphrase = errors.New("secrets.toml has encrypted tokens but no passphrase was given: set " + passphraseEnv + " or use -key-file")
+)
+
+// credentialUpdate is a single key in secrets.toml that needs to change
+//
+// an empty table means the key lives at the top of the file
+type credentialUpdate struct {
+	table string
+	key   string
+	value string
+	// secret values are encrypted before writing when a passphrase is available
+	secret bool
+	// remove deletes the key instead of setting it
+	remove bool
+}
+
+// saveCredentials persists the tokens in credentials to filename
+func saveCredentials(credentials serviceCredentials, filename string) error {
+	return updateCredentials(filename,
+		credentialUpdate{key: "discordToken", value: credentials.DiscordToken, secret: true},
+		credentialUpdate{table: "plex", key: "token", value: credentials.Plex.Token, secret: true},
+	)
+}
+
+// updateCredentials changes only the given keys in filename, leaving comments and
+// unknown keys alone. The file is locked while we work on it and replaced atomically
+func updateCredentials(filename string, updates ...credentialUpdate) error {
+	unlock, err := lockFile(filename)
+
+	if err != nil {
+		return err
+	}
+
+	defer unlock()
+
+	contents, err := ioutil.ReadFile(filename)
+
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	passphrase, err := getPassphrase()
+
+	if err != nil {
+		return err
+	}
+
+	for _, update := range updates {
+		if update.remove {
+			contents = removeTOMLValue(contents, update.table, update.key)
+			continue
+		}
+
+		value := update.value
+
+		if update.secret && value != "" && passphrase != nil {
+			if value, err = encryptToken(value, passphrase); err != nil {
+				return err
+			}
+		}
+
+		contents = setTOMLValue(contents, update.table, update.key, strconv.Quote(value))
+	}
+
+	return writeFileAtomic(filename, contents, 0600)
+}
+
+// writeFileAtomic writes data to a temp file next to filename and renames it
+// into place so readers never see a half written file
+func writeFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(filename)
+
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(filename)+".tmp")
+
+	if err != nil {
+		return err
+	}
+
+	tmpName := tmp.Name()
+
+	// clean up after ourselves if anything below fails
+	defer os.Remove(tmpName)
+
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpName, filename); err != nil {
+		return err
+	}
+
+	// make sure the rename itself hits the disk
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+
+	return nil
+}
+
+// setTOMLValue sets key to the raw toml value in the given table. Every other line
+// is copied over untouched. Missing keys go after the last key of their table, top-level
+// keys before the first table header, and missing tables are appended
+func setTOMLValue(contents []byte, table, key, value string) []byte {
+	lines := []string{}
+	newLine := key + " = " + value
+
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	currentTable := ""
+	replaced := false
+	// insertAt is the line after the last key or header of our table, -1 while the table is missing
+	insertAt := -1
+
+	if table == "" {
+		insertAt = 0
+	}
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+
+		if strings.HasPrefix(trimmed, "[") {
+			currentTable = parseTOMLTable(trimmed)
+		} else if !replaced && currentTable == table && parseTOMLKey(trimmed) == strings.ToLower(key) {
+			indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+
+			line = indent + newLine + tomlTrailingComment(trimmed)
+			replaced = true
+		}
+
+		lines = append(lines, line)
+
+		if currentTable == table && trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+			insertAt = len(lines)
+		}
+	}
+
+	if !replaced {
+		if insertAt < 0 {
+			if len(lines) > 0 {
+				lines = append(lines, "")
+			}
+
+			lines = append(lines, "["+table+"]", newLine)
+		} else {
+			lines = append(lines[:insertAt], append([]string{newLine}, lines[insertAt:]...)...)
+		}
+	}
+
+	var out bytes.Buffer
+
+	for _, line := range lines {
+		out.WriteString(line + "\n")
+	}
+
+	return out.Bytes()
+}
+
+// removeTOMLValue drops key from the given table. Every other line is copied over untouched
+func removeTOMLValue(contents []byte, table, key string) []byte {
+	var out bytes.Buffer
+
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	currentTable := ""
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+
+		if strings.HasPrefix(trimmed, "[") {
+			currentTable = parseTOMLTable(trimmed)
+		} else if currentTable == table && parseTOMLKey(trimmed) == strings.ToLower(key) {
+			continue
+		}
+
+		out.WriteString(line + "\n")
+	}
+
+	return out.Bytes()
+}
+
+// parseTOMLTable returns the name of a [table] header line
+func parseTOMLTable(line string) string {
+	line = strings.TrimPrefix(line, "[")
+
+	if i := strings.Index(line, "]"); i > -1 {
+		line = line[:i]
+	}
+
+	return strings.TrimSpace(line)
+}
+
+// parseTOMLKey returns the lowercased key of a key = value line
+//
+// toml keys are matched case-insensitively when decoding, so we do the same
+func parseTOMLKey(line string) string {
+	if strings.HasPrefix(line, "#") {
+		return ""
+	}
+
+	i := strings.Index(line, "=")
+
+	if i < 0 {
+		return ""
+	}
+
+	return strings.ToLower(strings.Trim(strings.TrimSpace(line[:i]), `"'`))
+}
+
+// tomlTrailingComment returns the comment after a key = "value" line, if any
+func tomlTrailingComment(line string) string {
+	value := strings.TrimSpace(line[strings.Index(line, "=")+1:])
+
+	if !strings.HasPrefix(value, `"`) {
+		return ""
+	}
+
+	for i := 1; i < len(value); i++ {
+		if value[i] == '\\' {
+			i++
+			continue
+		}
+
+		if value[i] == '"' {
+			if rest := strings.TrimSpace(value[i+1:]); strings.HasPrefix(rest, "#") {
+				return " " + rest
+			}
+
+			return ""
+		}
+	}
+
+	return ""
+}
+
+// getPassphrase returns the passphrase used for encrypting tokens, or nil if encryption is off
+func getPassphrase() ([]byte, error) {
+	if keyFilepath != "" {
+		key, err := ioutil.ReadFile(keyFilepath)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return bytes.TrimSpace(key), nil
+	}
+
+	if passphrase := os.Getenv(passphraseEnv); passphrase != "" {
+		return []byte(passphrase), nil
+	}
+
+	return nil, nil
+}
+
+func deriveKey(passphrase, salt []byte) ([]byte, error) {
+	return scrypt.Key(passphrase, salt, 1<<15, 8, 1, 32)
+}
+
+// encryptToken seals token with AES-GCM using a key derived from passphrase
+func encryptToken(token string, passphrase []byte) (string, error) {
+	salt := make([]byte, saltLen)
+
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return "", err
+	}
+
+	key, err := deriveKey(passphrase, salt)
+
+	if err != nil {
+		return "", err
+	}
+
+	block, err := aes.NewCipher(key)
+
+	if err != nil {
+		return "", err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	sealed := append(salt, nonce...)
+	sealed = gcm.Seal(sealed, nonce, []byte(token), nil)
+
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decryptToken reverses encryptToken. Tokens without the encrypted prefix are returned as is
+func decryptToken(token string, passphrase []byte) (string, error) {
+	if !strings.HasPrefix(token, encryptedPrefix) {
+		return token, nil
+	}
+
+	if passphrase == nil {
+		return "", errNoPassphrase
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(token, encryptedPrefix))
+
+	if err != nil {
+		return "", err
+	}
+
+	if len(sealed) < saltLen {
+		return "", errors.New("encrypted token is too short")
+	}
+
+	key, err := deriveKey(passphrase, sealed[:saltLen])
+
+	if err != nil {
+		return "", err
+	}
+
+	block, err := aes.NewCipher(key)
+
+	if err != nil {
+		return "", err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+
+	if err != nil {
+		return "", err
+	}
+
+	sealed = sealed[saltLen:]
+
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("encrypted token is too short")
+	}
+
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+
+	if err != nil {
+		return "", errors.New("could not decrypt token: wrong passphrase?")
+	}
+
+	return string(plain), nil
+}
+
+// decryptCredentials decrypts any tokens that were encrypted at rest
+func decryptCredentials(credentials *serviceCredentials) error {
+	passphrase, err := getPassphrase()
+
+	if err != nil {
+		return err
+	}
+
+	if credentials.DiscordToken, err = decryptToken(credentials.DiscordToken, passphrase); err != nil {
+		return err
+	}
+
+	if credentials.Plex.Token, err = decryptToken(credentials.Plex.Token, passphrase); err != nil {
+		return err
+	}
+
+	for guildID, guildCredentials := range credentials.Guilds {
+		if guildCredentials.Token, err = decryptToken(guildCredentials.Token, passphrase); err != nil {
+			return err
+		}
+
+		credentials.Guilds[guildID] = guildCredentials
+	}
+
+	return nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import "testing"
+
+func TestSetTOMLValue(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		table    string
+		key      string
+		value    string
+		want     string
+	}{
+		{
+			name:     "replaces a top-level key",
+			contents: "token = \"old\"\n[plex]\n",
+			key:      "token",
+			value:    `"new"`,
+			want:     "token = \"new\"\n[plex]\n",
+		},
+		{
+			name:     "keeps trailing comments",
+			contents: "token = \"old\" # plex token\n",
+			key:      "token",
+			value:    `"new"`,
+			want:     "token = \"new\" # plex token\n",
+		},
+		{
+			name:     "adds a missing top-level key before the first table",
+			contents: "discordToken = \"abc\"\n\n[plex]\ntoken = \"x\"\n",
+			key:      "keyword",
+			value:    `"!"`,
+			want:     "discordToken = \"abc\"\nkeyword = \"!\"\n\n[plex]\ntoken = \"x\"\n",
+		},
+		{
+			name:     "adds a top-level key to a file that starts with a table",
+			contents: "[plex]\ntoken = \"x\"\n",
+			key:      "keyword",
+			value:    `"!"`,
+			want:     "keyword = \"!\"\n[plex]\ntoken = \"x\"\n",
+		},
+		{
+			name:     "adds a top-level key to an empty file",
+			contents: "",
+			key:      "keyword",
+			value:    `"!"`,
+			want:     "keyword = \"!\"\n",
+		},
+		{
+			name:     "replaces a key in a table only",
+			contents: "token = \"top\"\n[plex]\ntoken = \"x\"\n",
+			table:    "plex",
+			key:      "token",
+			value:    `"y"`,
+			want:     "token = \"top\"\n[plex]\ntoken = \"y\"\n",
+		},
+		{
+			name:     "adds a missing key after the last key of its table",
+			contents: "[plex]\ntoken = \"x\"\n\n# discord bot\n[discord]\n",
+			table:    "plex",
+			key:      "host",
+			value:    `"http://localhost:32400"`,
+			want:     "[plex]\ntoken = \"x\"\nhost = \"http://localhost:32400\"\n\n# discord bot\n[discord]\n",
+		},
+		{
+			name:     "appends a missing table",
+			contents: "discordToken = \"abc\"\n",
+			table:    "plex",
+			key:      "token",
+			value:    `"x"`,
+			want:     "discordToken = \"abc\"\n\n[plex]\ntoken = \"x\"\n",
+		},
+		{
+			name:     "matches keys ignoring case",
+			contents: "Token = \"old\"\n",
+			key:      "token",
+			value:    `"new"`,
+			want:     "token = \"new\"\n",
+		},
+		{
+			name:     "keeps the tables of each guild apart",
+			contents: "[guilds.1]\ntoken = \"a\"\n[guilds.2]\ntoken = \"b\"\n",
+			table:    "guilds.2",
+			key:      "token",
+			value:    `"c"`,
+			want:     "[guilds.1]\ntoken = \"a\"\n[guilds.2]\ntoken = \"c\"\n",
+		},
+		{
+			name:     "adds a key to a guild table before the next guild",
+			contents: "[guilds.1]\ntoken = \"a\"\n\n[guilds.2]\ntoken = \"b\"\n",
+			table:    "guilds.1",
+			key:      "host",
+			value:    `"h"`,
+			want:     "[guilds.1]\ntoken = \"a\"\nhost = \"h\"\n\n[guilds.2]\ntoken = \"b\"\n",
+		},
+		{
+			name:     "appends the table of a new guild",
+			contents: "[plex]\ntoken = \"x\"\n",
+			table:    "guilds.3",
+			key:      "token",
+			value:    `"y"`,
+			want:     "[plex]\ntoken = \"x\"\n\n[guilds.3]\ntoken = \"y\"\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := string(setTOMLValue([]byte(test.contents), test.table, test.key, test.value))
+
+			if got != test.want {
+				t.Errorf("got\n%q\nwant\n%q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRemoveTOMLValue(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		table    string
+		key      string
+		want     string
+	}{
+		{
+			name:     "removes a top-level key",
+			contents: "discordToken = \"abc\"\nplexToken = \"x\"\n[guilds.1]\nplexToken = \"y\"\n",
+			key:      "plexToken",
+			want:     "discordToken = \"abc\"\n[guilds.1]\nplexToken = \"y\"\n",
+		},
+		{
+			name:     "removes a key in a table only",
+			contents: "plexToken = \"x\"\n[guilds.1]\nplexToken = \"y\"\n",
+			table:    "guilds.1",
+			key:      "plexToken",
+			want:     "plexToken = \"x\"\n[guilds.1]\n",
+		},
+		{
+			name:     "leaves comments and missing keys alone",
+			contents: "# plexToken = \"x\"\ndiscordToken = \"abc\"\n",
+			key:      "plexToken",
+			want:     "# plexToken = \"x\"\ndiscordToken = \"abc\"\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := string(removeTOMLValue([]byte(test.contents), test.table, test.key))
+
+			if got != test.want {
+				t.Errorf("got\n%q\nwant\n%q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestEncryptToken(t *testing.T) {
+	passphrase := []byte("correct horse battery staple")
+
+	encrypted, err := encryptToken("plex-token", passphrase)
+
+	if err != nil {
+		t.Fatalf("encryptToken() failed: %v", err)
+	}
+
+	if encrypted == "plex-token" {
+		t.Fatal("encryptToken() returned the token in the clear")
+	}
+
+	tests := []struct {
+		name       string
+		token      string
+		passphrase []byte
+		want       string
+		wantErr    bool
+	}{
+		{name: "round trip", token: encrypted, passphrase: passphrase, want: "plex-token"},
+		{name: "wrong passphrase", token: encrypted, passphrase: []byte("wrong"), wantErr: true},
+		{name: "no passphrase", token: encrypted, wantErr: true},
+		{name: "plain tokens pass through", token: "plex-token", want: "plex-token"},
+		{name: "garbage", token: encryptedPrefix + "bm9wZQ==", passphrase: passphrase, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := decryptToken(test.token, test.passphrase)
+
+			if (err != nil) != test.wantErr {
+				t.Fatalf("decryptToken() error = %v, wantErr %v", err, test.wantErr)
+			}
+
+			if got != test.want {
+				t.Errorf("decryptToken() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/jrudio/go-plex-client"
+)
+
+// sessionCheckInterval is how often dobby looks at what is playing for stream limits and alerts
+const sessionCheckInterval = time.Minute
+
+// playSession is something being played on the plex media server right now
+type playSession struct {
+	mediaItem
+	SessionKey string `json:"sessionKey"`
+	ViewOffset int64  `json:"viewOffset"`
+	Duration   int64  `json:"duration"`
+	User       struct {
+		ID    string `json:"id"`
+		Title string `json:"title"`
+	} `json:"User"`
+	Player struct {
+		Title    string `json:"title"`
+		Product  string `json:"product"`
+		Platform string `json:"platform"`
+		State    string `json:"state"`
+		Local    bool   `json:"local"`
+	} `json:"Player"`
+	Session struct {
+		ID string `json:"id"`
+		// Bandwidth is in kbps
+		Bandwidth int    `json:"bandwidth"`
+		Location  string `json:"location"`
+	} `json:"Session"`
+	TranscodeSession *struct {
+		VideoDecision string  `json:"videoDecision"`
+		AudioDecision string  `json:"audioDecision"`
+		Height        int     `json:"height"`
+		Throttled     bool    `json:"throttled"`
+		Speed         float64 `json:"speed"`
+	} `json:"TranscodeSession"`
+}
+
+// sessionContainer is the response of /status/sessions
+type sessionContainer struct {
+	MediaContainer struct {
+		Size     int           `json:"size"`
+		Metadata []playSession `json:"Metadata"`
+	} `json:"MediaContainer"`
+}
+
+// getSessions lists what is being played on the plex media server, oldest session first
+func getSessions(plexClient *plex.Plex) ([]playSession, error) {
+	var result sessionContainer
+
+	if err := pmsGet(plexClient, "/status/sessions", &result); err != nil {
+		return nil, err
+	}
+
+	sessions := result.MediaContainer.Metadata
+
+	sort.SliceStable(sessions, func(i, j int) bool {
+		return sessionNumber(sessions[i]) < sessionNumber(sessions[j])
+	})
+
+	return sessions, nil
+}
+
+// sessionNumber orders sessions, plex hands out session keys counting up
+func sessionNumber(s playSession) int {
+	n := 0
+
+	fmt.Sscan(s.SessionKey, &n)
+
+	return n
+}
+
+// isTranscoding is true when the video or audio of a session is converted for the player
+func (s playSession) isTranscoding() bool {
+	return s.TranscodeSession != nil &&
+		(s.TranscodeSession.VideoDecision == "transcode" || s.TranscodeSession.AudioDecision == "transcode")
+}
+
+// decision is how plex gets the session to the player: direct play, direct stream or transcode
+func (s playSession) decision() string {
+	switch {
+	case s.TranscodeSession == nil:
+		return "direct play"
+	case s.isTranscoding():
+		return "transcode"
+	}
+
+	return "direct stream"
+}
+
+// quality is the resolution of the file and, when the video is transcoded, what it is transcoded to
+func (s playSession) quality() string {
+	quality := s.resolution()
+
+	if s.TranscodeSession != nil && s.TranscodeSession.VideoDecision == "transcode" && s.TranscodeSession.Height > 0 {
+		quality = strings.TrimSpace(fmt.Sprintf("%s → %dp", quality, s.TranscodeSession.Height))
+	}
+
+	return quality
+}
+
+// device is the app and platform a session is played on
+func (s playSession) device() string {
+	device := s.Player.Product
+
+	if s.Player.Platform != "" && !strings.EqualFold(s.Player.Platform, s.Player.Product) {
+		device = strings.TrimSpace(device + " on " + s.Player.Platform)
+	}
+
+	if s.Player.Title != "" {
+		device += " (" + s.Player.Title + ")"
+	}
+
+	return device
+}
+
+// progress is how far into the item a session is, like 45:10 / 2:16:17 (33%)
+func (s playSession) progress() string {
+	if s.Duration <= 0 {
+		return formatPlayTime(s.ViewOffset)
+	}
+
+	return fmt.Sprintf("%s / %s (%d%%)", formatPlayTime(s.ViewOffset), formatPlayTime(s.Duration), s.ViewOffset*100/s.Duration)
+}
+
+// formatPlayTime turns milliseconds into h:mm:ss or m:ss
+func formatPlayTime(ms int64) string {
+	seconds := ms / 1000
+
+	if seconds >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+	}
+
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
+
+// formatBandwidth turns kbps into something like 8.2 Mbps
+func formatBandwidth(kbps int) string {
+	if kbps < 1000 {
+		return fmt.Sprintf("%d kbps", kbps)
+	}
+
+	return fmt.Sprintf("%.1f Mbps", float64(kbps)/1000)
+}
+
+// describeSession is one line per session for nowplaying and the commands acting on sessions
+func describeSession(services *clients, guildID string, s playSession) string {
+	line := fmt.Sprintf("`#%s` **%s**", s.SessionKey, s.User.Title)
+
+	if account, ok := findAccountByPlex(services, guildID, sharedServer{Username: s.User.Title}); ok {
+		line += " (<@" + account.DiscordID + ">)"
+	}
+
+	line += " · " + s.name() + "\n"
+
+	details := []string{s.device()}
+
+	if s.Player.State != "" {
+		details = append(details, s.Player.State+" "+s.progress())
+	} else {
+		details = append(details, s.progress())
+	}
+
+	decision := s.decision()
+
+	if quality := s.quality(); quality != "" {
+		decision += " " + quality
+	}
+
+	if s.TranscodeSession != nil && s.TranscodeSession.Throttled {
+		decision += ", throttled"
+	}
+
+	details = append(details, decision)
+
+	if s.Session.Bandwidth > 0 {
+		details = append(details, formatBandwidth(s.Session.Bandwidth))
+	}
+
+	if s.Session.Location != "" {
+		details = append(details, s.Session.Location)
+	}
+
+	return line + "    " + strings.Join(details, " · ")
+}
+
+// guildServer is a guild and the plex server it is linked to
+type guildServer struct {
+	guildID string
+	link    *plexLink
+}
+
+// watchSessions periodically checks what is playing in guilds that limit streams or have alerts
+func watchSessions(commandList d, services *clients) {
+	// notified remembers, per guild and plex server, the sessions that were already acted on.
+	// guilds sharing a server each apply their own action
+	notified := map[guildServer]map[string]bool{}
+	// lastAlerts remembers, per guild, when each alert rule last went off
+	lastAlerts := map[string]map[string]time.Time{}
+
+	for {
+		time.Sleep(sessionCheckInterval)
+
+		guilds := map[string]guildSettings{}
+		guildIDs := []string{}
+
+		services.data.view(func(data dobbyData) {
+			for guildID, settings := range data.Guilds {
+				if settings.StreamLimit > 0 || len(settings.StreamLimits) > 0 || len(settings.SessionAlerts) > 0 {
+					guilds[guildID] = settings
+					guildIDs = append(guildIDs, guildID)
+				}
+			}
+		})
+
+		// guilds sharing a server are checked in the same order every time
+		sort.Strings(guildIDs)
+
+		fetched := map[*plexLink][]playSession{}
+
+		for _, guildID := range guildIDs {
+			settings := guilds[guildID]
+			link := services.getPlexLink(guildID)
+
+			if !link.authorized() {
+				continue
+			}
+
+			sessions, ok := fetched[link]
+
+			if !ok {
+				var err error
+
+				if sessions, err = getSessions(link.client()); err != nil {
+					fmt.Printf("watchSessions() - could not fetch sessions of guild %s: %v\n", guildID, err)
+					continue
+				}
+
+				fetched[link] = sessions
+			}
+
+			key := guildServer{guildID: guildID, link: link}
+
+			if notified[key] == nil {
+				notified[key] = map[string]bool{}
+			}
+
+			if lastAlerts[guildID] == nil {
+				lastAlerts[guildID] = map[string]time.Time{}
+			}
+
+			checkStreamLimits(commandList, services, guildID, settings, link, sessions, notified[key])
+			checkSessionAlerts(commandList, services, guildID, settings, sessions, lastAlerts[guildID])
+		}
+	}
+}
+
+// nowPlaying lists what is being streamed from the plex server
+//
+// command: nowplaying
+func nowPlaying(commandList d, services *clients) func(m *discordgo.Message, args ...string) bool {
+	return func(m *discordgo.Message, args ...string) bool {
+		channelID := m.ChannelID
+		guildID := commandList.getGuildID(channelID)
+		link := services.getPlexLink(guildID)
+
+		if !link.authorized() {
+			commandList.showError(channelID, "dobby is not linked to a plex server -- run `link`")
+			return false
+		}
+
+		sessions, err := getSessions(link.client())
+
+		if err != nil {
+			fmt.Printf("nowPlaying() - could not fetch sessions: %v\n", err)
+			commandList.showError(channelID, "could not fetch what is playing on your plex server")
+			return false
+		}
+
+		if len(sessions) == 0 {
+			commandList.discord.ChannelMessageSend(channelID, "nothing is playing right now")
+			return true
+		}
+
+		transcodes := 0
+		bandwidth := 0
+		lines := make([]string, len(sessions))
+
+		for i, s := range sessions {
+			if s.isTranscoding() {
+				transcodes++
+			}
+
+			bandwidth += s.Session.Bandwidth
+			lines[i] = describeSession(services, guildID, s)
+		}
+
+		streams := "streams"
+
+		if len(sessions) == 1 {
+			streams = "stream"
+		}
+
+		message := fmt.Sprintf("**%d %s** · %d transcoding · %s\n\n%s",
+			len(sessions), streams, transcodes, formatBandwidth(bandwidth), strings.Join(lines, "\n"))
+
+		if err := commandList.sendLongMessage(channelID, message); err != nil {
+			fmt.Printf("nowPlaying() - message sent to discord failed: %v\n", err)
+		}
+
+		return true
+	}
+}
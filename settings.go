@@ -0,0 +1,351 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// guildSettings are per guild options that admins change from discord
+type guildSettings struct {
+	// AdminChannelID is where dobby posts things admins need to act on
+	AdminChannelID string `toml:"adminChannelID"`
+	// RequireApproval makes members go through request-access instead of invite
+	RequireApproval bool `toml:"requireApproval"`
+	// LeavePolicy is what happens to the plex access of members that leave the guild
+	LeavePolicy string `toml:"leavePolicy"`
+	// LeaveGrace is how long the grace leave policy waits, like 7d
+	LeaveGrace string `toml:"leaveGrace"`
+	// AutoPrune is how long users can go without streaming before they are removed
+	// automatically, like 90d. empty turns it off
+	AutoPrune string `toml:"autoPrune"`
+	// InviteQuota is how many invites a member can send, 0 is unlimited
+	InviteQuota int `toml:"inviteQuota"`
+	// AnnounceChannelID is where new media is announced, AnnounceRoutes send some libraries elsewhere
+	AnnounceChannelID string          `toml:"announceChannelID"`
+	AnnounceRoutes    []announceRoute `toml:"announceRoutes"`
+	// StreamLimit is how many streams a plex user can play at once, 0 is unlimited.
+	// StreamLimits overrides it for members of some roles
+	StreamLimit  int               `toml:"streamLimit"`
+	StreamLimits []roleStreamLimit `toml:"streamLimits"`
+	// StreamLimitAction is what dobby does about streams over the limit
+	StreamLimitAction string `toml:"streamLimitAction"`
+	// SessionAlerts are rules about what is playing that admins get alerted about,
+	// AlertCooldown is how long a rule stays quiet after it alerted, like 30m
+	SessionAlerts []sessionAlert `toml:"sessionAlerts"`
+	AlertCooldown string         `toml:"alertCooldown"`
+	// Roles maps discord roles to the plex libraries their members get
+	Roles []roleLibraries `toml:"roles"`
+}
+
+const (
+	leavePolicyOff    = "off"
+	leavePolicyAlert  = "alert"
+	leavePolicyRevoke = "revoke"
+	leavePolicyGrace  = "grace"
+
+	defaultLeaveGrace = "7d"
+
+	streamLimitWarn = "warn"
+	streamLimitDM   = "dm"
+	streamLimitKill = "kill"
+)
+
+// guildSetting describes a setting admins can change with the settings command
+type guildSetting struct {
+	description string
+	get         func(settings guildSettings) string
+	set         func(settings *guildSettings, value string) error
+}
+
+var guildSettingList = map[string]guildSetting{
+	"admin-channel": {
+		description: "channel for access requests and alerts, e.g. `#admins`",
+		get: func(settings guildSettings) string {
+			if settings.AdminChannelID == "" {
+				return "not set"
+			}
+
+			return "<#" + settings.AdminChannelID + ">"
+		},
+		set: func(settings *guildSettings, value string) error {
+			channelID, ok := parseChannelMention(value)
+
+			if !ok {
+				return fmt.Errorf("`%s` is not a channel -- use `#channel`", value)
+			}
+
+			settings.AdminChannelID = channelID
+
+			return nil
+		},
+	},
+	"require-approval": {
+		description: "`true` makes members use `request-access` instead of `invite`",
+		get: func(settings guildSettings) string {
+			return strconv.FormatBool(settings.RequireApproval)
+		},
+		set: func(settings *guildSettings, value string) error {
+			requireApproval, err := strconv.ParseBool(value)
+
+			if err != nil {
+				return fmt.Errorf("`%s` should be true or false", value)
+			}
+
+			settings.RequireApproval = requireApproval
+
+			return nil
+		},
+	},
+	"leave-policy": {
+		description: "when a member leaves: `off`, `alert` admins, `revoke` their plex access or `grace` to revoke after `leave-grace`",
+		get: func(settings guildSettings) string {
+			if settings.LeavePolicy == "" {
+				return leavePolicyOff
+			}
+
+			return settings.LeavePolicy
+		},
+		set: func(settings *guildSettings, value string) error {
+			switch value {
+			case leavePolicyOff, leavePolicyAlert, leavePolicyRevoke, leavePolicyGrace:
+				settings.LeavePolicy = value
+				return nil
+			}
+
+			return fmt.Errorf("`%s` should be off, alert, revoke or grace", value)
+		},
+	},
+	"leave-grace": {
+		description: "how long the `grace` leave policy waits before revoking, e.g. `7d`",
+		get: func(settings guildSettings) string {
+			if settings.LeaveGrace == "" {
+				return defaultLeaveGrace
+			}
+
+			return settings.LeaveGrace
+		},
+		set: func(settings *guildSettings, value string) error {
+			if _, err := parseDuration(value); err != nil {
+				return err
+			}
+
+			settings.LeaveGrace = value
+
+			return nil
+		},
+	},
+	"auto-prune": {
+		description: "remove users that have not streamed for this long every day, e.g. `90d`, or `off`",
+		get: func(settings guildSettings) string {
+			if settings.AutoPrune == "" {
+				return "off"
+			}
+
+			return settings.AutoPrune
+		},
+		set: func(settings *guildSettings, value string) error {
+			if value == "off" {
+				settings.AutoPrune = ""
+				return nil
+			}
+
+			if _, err := parsePruneWindow(value); err != nil {
+				return err
+			}
+
+			settings.AutoPrune = value
+
+			return nil
+		},
+	},
+	"invite-quota": {
+		description: "how many people a member can invite with `invite` or `redeem`, `0` for no limit. admins have no limit",
+		get: func(settings guildSettings) string {
+			if settings.InviteQuota == 0 {
+				return "no limit"
+			}
+
+			return strconv.Itoa(settings.InviteQuota)
+		},
+		set: func(settings *guildSettings, value string) error {
+			quota, err := strconv.Atoi(value)
+
+			if err != nil || quota < 0 {
+				return fmt.Errorf("`%s` should be a number, 0 for no limit", value)
+			}
+
+			settings.InviteQuota = quota
+
+			return nil
+		},
+	},
+	"stream-limit": {
+		description: "how many streams a plex user can play at once, `0` for no limit. `stream-limits` sets it per role",
+		get: func(settings guildSettings) string {
+			if settings.StreamLimit == 0 {
+				return "no limit"
+			}
+
+			return strconv.Itoa(settings.StreamLimit)
+		},
+		set: func(settings *guildSettings, value string) error {
+			limit, err := strconv.Atoi(value)
+
+			if err != nil || limit < 0 {
+				return fmt.Errorf("`%s` should be a number, 0 for no limit", value)
+			}
+
+			settings.StreamLimit = limit
+
+			return nil
+		},
+	},
+	"stream-limit-action": {
+		description: "what to do about streams over the limit: `warn` admins, `dm` the member or `kill` the newest stream",
+		get: func(settings guildSettings) string {
+			if settings.StreamLimitAction == "" {
+				return streamLimitWarn
+			}
+
+			return settings.StreamLimitAction
+		},
+		set: func(settings *guildSettings, value string) error {
+			switch value {
+			case streamLimitWarn, streamLimitDM, streamLimitKill:
+				settings.StreamLimitAction = value
+				return nil
+			}
+
+			return fmt.Errorf("`%s` should be warn, dm or kill", value)
+		},
+	},
+	"alert-cooldown": {
+		description: "how long an `alerts` rule stays quiet after it alerted, e.g. `30m`",
+		get: func(settings guildSettings) string {
+			if settings.AlertCooldown == "" {
+				return defaultAlertCooldown
+			}
+
+			return settings.AlertCooldown
+		},
+		set: func(settings *guildSettings, value string) error {
+			if _, err := parseDuration(value); err != nil {
+				return err
+			}
+
+			settings.AlertCooldown = value
+
+			return nil
+		},
+	},
+}
+
+// alertAdmins posts a message in the guild's admin channel
+//
+// the message is only logged when no admin channel is set
+func alertAdmins(commandList d, services *clients, guildID, message string) {
+	adminChannelID := services.getGuildSettings(guildID).AdminChannelID
+
+	if adminChannelID == "" {
+		fmt.Printf("alertAdmins() - no admin channel in guild %s: %s\n", guildID, message)
+		return
+	}
+
+	if err := commandList.sendLongMessage(adminChannelID, message); err != nil {
+		fmt.Printf("alertAdmins() - message sent to discord failed: %v\n", err)
+	}
+}
+
+// getGuildSettings returns the settings of a guild
+func (c *clients) getGuildSettings(guildID string) guildSettings {
+	var settings guildSettings
+
+	c.data.view(func(data dobbyData) {
+		settings = data.Guilds[guildID]
+	})
+
+	return settings
+}
+
+// updateGuildSettings changes the settings of a guild and saves them
+func (c *clients) updateGuildSettings(guildID string, update func(settings *guildSettings)) error {
+	return c.data.update(func(data *dobbyData) {
+		if data.Guilds == nil {
+			data.Guilds = map[string]guildSettings{}
+		}
+
+		settings := data.Guilds[guildID]
+		update(&settings)
+		data.Guilds[guildID] = settings
+	})
+}
+
+// changeSettings shows or changes the settings of the guild
+//
+// command: settings [name value]
+func changeSettings(commandList d, services *clients) func(m *discordgo.Message, args ...string) bool {
+	return func(m *discordgo.Message, args ...string) bool {
+		channelID := m.ChannelID
+		guildID := commandList.getGuildID(channelID)
+
+		if guildID == "" {
+			commandList.showError(channelID, "settings can only be changed from a server channel")
+			return false
+		}
+
+		if len(args) < 2 {
+			current := services.getGuildSettings(guildID)
+			names := make([]string, 0, len(guildSettingList))
+
+			for name := range guildSettingList {
+				names = append(names, name)
+			}
+
+			sort.Strings(names)
+
+			message := "Settings:\n"
+
+			for _, name := range names {
+				setting := guildSettingList[name]
+				message += fmt.Sprintf("`%s` = %s -- %s\n", name, setting.get(current), setting.description)
+			}
+
+			message += "use `settings <name> <value>` to change one"
+
+			commandList.discord.ChannelMessageSend(channelID, message)
+
+			return true
+		}
+
+		setting, ok := guildSettingList[args[0]]
+
+		if !ok {
+			commandList.showError(channelID, fmt.Sprintf("unknown setting `%s`", args[0]))
+			return false
+		}
+
+		var setErr error
+
+		err := services.updateGuildSettings(guildID, func(settings *guildSettings) {
+			setErr = setting.set(settings, args[1])
+		})
+
+		if setErr != nil {
+			commandList.showError(channelID, setErr.Error())
+			return false
+		}
+
+		if err != nil {
+			fmt.Printf("changeSettings() - could not save settings: %v\n", err)
+			commandList.showError(channelID, "`internal error - could not save settings`")
+			return false
+		}
+
+		commandList.discord.ChannelMessageSend(channelID, fmt.Sprintf("`%s` is now %s", args[0], setting.get(services.getGuildSettings(guildID))))
+
+		return true
+	}
+}
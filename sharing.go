@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jrudio/go-plex-client"
+)
+
+// sharingSettings are the libraries and restrictions a plex friend gets
+type sharingSettings struct {
+	// libraries are library titles -- no libraries shares all of them
+	libraries         []string
+	allowSync         string
+	allowCameraUpload string
+	allowChannels     string
+	filterMovies      string
+	filterTelevision  string
+	filterMusic       string
+}
+
+// sharedServerBody is the request body of plex.tv/api/v2/shared_servers
+type sharedServerBody struct {
+	InvitedEmail      string             `json:"invitedEmail"`
+	LibrarySectionIDs []int              `json:"librarySectionIds"`
+	MachineIdentifier string             `json:"machineIdentifier"`
+	Settings          sharedServerConfig `json:"settings"`
+}
+
+type sharedServerConfig struct {
+	AllowSync         string `json:"allowSync"`
+	AllowCameraUpload string `json:"allowCameraUpload"`
+	AllowChannels     string `json:"allowChannels"`
+	FilterMovies      string `json:"filterMovies"`
+	FilterTelevision  string `json:"filterTelevision"`
+	FilterMusic       string `json:"filterMusic"`
+}
+
+// sharingOptions are the options parseSharingSettings reads
+var sharingOptions = optionList{
+	"libraries":           true,
+	"allow-sync":          false,
+	"allow-camera-upload": false,
+	"allow-channels":      false,
+	"filter-movies":       true,
+	"filter-tv":           true,
+	"filter-music":        true,
+}
+
+// parseSharingSettings reads sharing options given to a command
+//
+// options: --libraries Movies,TV --allow-sync=false --allow-camera-upload=false
+// --allow-channels=false --filter-movies "label=kids" --filter-tv "label=kids" --filter-music "label=kids"
+func parseSharingSettings(options map[string]string) (sharingSettings, error) {
+	settings := sharingSettings{}
+
+	if libraries, ok := options["libraries"]; ok {
+		settings.libraries = splitLibraries(libraries)
+	}
+
+	allowOptions := map[string]*string{
+		"allow-sync":          &settings.allowSync,
+		"allow-camera-upload": &settings.allowCameraUpload,
+		"allow-channels":      &settings.allowChannels,
+	}
+
+	for option, setting := range allowOptions {
+		value, ok := options[option]
+
+		if !ok {
+			continue
+		}
+
+		allow, err := strconv.ParseBool(value)
+
+		if err != nil {
+			return settings, fmt.Errorf("`--%s` should be true or false", option)
+		}
+
+		*setting = "0"
+
+		if allow {
+			*setting = "1"
+		}
+	}
+
+	settings.filterMovies = options["filter-movies"]
+	settings.filterTelevision = options["filter-tv"]
+	settings.filterMusic = options["filter-music"]
+
+	for _, filter := range []string{settings.filterMovies, settings.filterTelevision, settings.filterMusic} {
+		if filter != "" && !strings.Contains(filter, "=") {
+			return settings, fmt.Errorf("filter `%s` should look like `label=kids`", filter)
+		}
+	}
+
+	return settings, nil
+}
+
+// splitLibraries splits a comma separated list of library titles
+func splitLibraries(libraries string) []string {
+	titles := []string{}
+
+	for _, library := range strings.Split(libraries, ",") {
+		if library = strings.TrimSpace(library); library != "" {
+			titles = append(titles, library)
+		}
+	}
+
+	return titles
+}
+
+// resolveLibraries turns library titles into plex.tv section ids
+//
+// titles are matched case-insensitively
+func resolveLibraries(plexClient *plex.Plex, machineID string, libraries []string) ([]int, error) {
+	if len(libraries) == 0 {
+		return []int{}, nil
+	}
+
+	sections, err := plexClient.GetSections(machineID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return matchLibraries(sections, libraries)
+}
+
+// matchLibraries finds the section ids of library titles, ignoring case
+func matchLibraries(sections []plex.ServerSections, libraries []string) ([]int, error) {
+	ids := make([]int, 0, len(libraries))
+	unknown := []string{}
+
+	for _, library := range libraries {
+		found := false
+
+		for _, section := range sections {
+			if strings.EqualFold(strings.TrimSpace(section.Title), library) {
+				ids = append(ids, section.ID)
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			unknown = append(unknown, library)
+		}
+	}
+
+	if len(unknown) > 0 {
+		available := make([]string, len(sections))
+
+		for i, section := range sections {
+			available[i] = strings.TrimSpace(section.Title)
+		}
+
+		return nil, fmt.Errorf("unknown libraries: %s\navailable libraries: %s",
+			strings.Join(unknown, ", "),
+			strings.Join(available, ", "))
+	}
+
+	return ids, nil
+}
+
+// inviteFriend invites usernameOrEmail to the server with the given sharing settings.
+// libraryIDs come from resolveLibraries
+//
+// go-plex-client's InviteFriend only supports a label filter so we talk to plex.tv ourselves
+func inviteFriend(plexClient *plex.Plex, machineID, usernameOrEmail string, libraryIDs []int, settings sharingSettings) error {
+	body, err := json.Marshal(sharedServerBody{
+		InvitedEmail:      usernameOrEmail,
+		LibrarySectionIDs: libraryIDs,
+		MachineIdentifier: machineID,
+		Settings: sharedServerConfig{
+			AllowSync:         settings.allowSync,
+			AllowCameraUpload: settings.allowCameraUpload,
+			AllowChannels:     settings.allowChannels,
+			FilterMovies:      settings.filterMovies,
+			FilterTelevision:  settings.filterTelevision,
+			FilterMusic:       settings.filterMusic,
+		},
+	})
+
+	if err != nil {
+		return err
+	}
+
+	resp, err := plexTVRequest(plexClient, "POST", plexTVURL+"/api/v2/shared_servers", body)
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return newPlexAPIError(resp)
+	}
+
+	return nil
+}
+
+// describeSharing summarizes sharing settings for a discord message
+func describeSharing(settings sharingSettings) string {
+	description := "libraries: all"
+
+	if len(settings.libraries) > 0 {
+		description = "libraries: " + strings.Join(settings.libraries, ", ")
+	}
+
+	if settings.allowSync == "1" {
+		description += ", sync allowed"
+	}
+
+	if settings.filterMovies != "" {
+		description += ", movies filtered by " + settings.filterMovies
+	}
+
+	if settings.filterTelevision != "" {
+		description += ", tv filtered by " + settings.filterTelevision
+	}
+
+	if settings.filterMusic != "" {
+		description += ", music filtered by " + settings.filterMusic
+	}
+
+	return description
+}
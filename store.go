@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+)
+
+const dataFilepath = "./data.toml"
+
+// dobbyData is everything dobby remembers between restarts that is not a credential
+type dobbyData struct {
+	Invites []trackedInvite `toml:"invites"`
+	// Guilds holds the settings of each guild keyed by guild id
+	Guilds         map[string]guildSettings `toml:"guilds"`
+	AccessRequests []accessRequest          `toml:"accessRequests"`
+	NextRequestID  int                      `toml:"nextRequestID"`
+	Accounts       []linkedAccount          `toml:"accounts"`
+	Revocations    []scheduledRevocation    `toml:"revocations"`
+	Trials         []trial                  `toml:"trials"`
+	Quotas         []memberQuota            `toml:"quotas"`
+	InviteCodes    []inviteCode             `toml:"inviteCodes"`
+	Announced      []announceState          `toml:"announced"`
+	RoleMembers    []roleMember             `toml:"roleMembers"`
+}
+
+// store keeps dobbyData in memory and saves it to disk on every change
+type store struct {
+	data dobbyData
+	path string
+	lock sync.Mutex
+}
+
+// loadStore reads the data file at path. A missing file is an empty store
+func loadStore(path string) (*store, error) {
+	s := &store{path: path}
+
+	fileBytes, err := ioutil.ReadFile(path)
+
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+
+	if err != nil {
+		return s, err
+	}
+
+	if err := toml.Unmarshal(fileBytes, &s.data); err != nil {
+		return s, err
+	}
+
+	return s, nil
+}
+
+// view calls fn with a read-only look at the data
+func (s *store) view(fn func(data dobbyData)) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	fn(s.data)
+}
+
+// update calls fn to change the data and saves the result
+func (s *store) update(fn func(data *dobbyData)) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	fn(&s.data)
+
+	var buf bytes.Buffer
+
+	if err := toml.NewEncoder(&buf).Encode(s.data); err != nil {
+		return err
+	}
+
+	return writeFileAtomic(s.path, buf.Bytes(), 0600)
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// plexOwnerID is the plex media server's user id for the owner of the server, who has no limit
+const plexOwnerID = "1"
+
+// roleStreamLimit gives members of a discord role their own stream limit
+type roleStreamLimit struct {
+	RoleID string `toml:"roleID"`
+	// Limit is how many streams members of the role can play at once, 0 is unlimited
+	Limit int `toml:"limit"`
+}
+
+// describeLimit is a stream limit for people to read
+func describeLimit(limit int) string {
+	if limit == 0 {
+		return "no limit"
+	}
+
+	if limit == 1 {
+		return "1 stream"
+	}
+
+	return fmt.Sprintf("%d streams", limit)
+}
+
+// memberRoles returns the roles of a guild member, from the state cache when it has them
+func memberRoles(discord *discordgo.Session, guildID, discordID string) ([]string, error) {
+	if member, err := discord.State.Member(guildID, discordID); err == nil {
+		return member.Roles, nil
+	}
+
+	member, err := discord.GuildMember(guildID, discordID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return member.Roles, nil
+}
+
+// userStreamLimit is how many streams a plex user can play at once and the discord member linked to them.
+// the most generous role limit of the member wins over the guild's limit
+func userStreamLimit(commandList d, services *clients, guildID string, settings guildSettings, plexUser string) (int, string) {
+	account, ok := findAccountByPlex(services, guildID, sharedServer{Username: plexUser})
+
+	if !ok {
+		return settings.StreamLimit, ""
+	}
+
+	if len(settings.StreamLimits) == 0 {
+		return settings.StreamLimit, account.DiscordID
+	}
+
+	roles, err := memberRoles(commandList.discord, guildID, account.DiscordID)
+
+	if err != nil {
+		// they may have left, the guild's limit still applies
+		return settings.StreamLimit, account.DiscordID
+	}
+
+	limit := -1
+
+	for _, tier := range settings.StreamLimits {
+		if !containsFold(roles, tier.RoleID) {
+			continue
+		}
+
+		if tier.Limit == 0 {
+			return 0, account.DiscordID
+		}
+
+		if tier.Limit > limit {
+			limit = tier.Limit
+		}
+	}
+
+	if limit == -1 {
+		return settings.StreamLimit, account.DiscordID
+	}
+
+	return limit, account.DiscordID
+}
+
+// checkStreamLimits acts on plex users playing more streams than they are allowed.
+//
+// notified holds the ids of sessions already acted on so each excess stream is handled once
+func checkStreamLimits(commandList d, services *clients, guildID string, settings guildSettings, link *plexLink, sessions []playSession, notified map[string]bool) {
+	byUser := map[string][]playSession{}
+	playing := map[string]bool{}
+
+	for _, s := range sessions {
+		playing[s.Session.ID] = true
+
+		// paused streams don't use the server, only what is playing counts
+		if s.User.ID == plexOwnerID || s.Player.State == "paused" {
+			continue
+		}
+
+		byUser[s.User.Title] = append(byUser[s.User.Title], s)
+	}
+
+	for id := range notified {
+		if !playing[id] {
+			delete(notified, id)
+		}
+	}
+
+	for plexUser, userSessions := range byUser {
+		limit, discordID := userStreamLimit(commandList, services, guildID, settings, plexUser)
+
+		if limit == 0 || len(userSessions) <= limit {
+			continue
+		}
+
+		// sessions are oldest first so the newest are over the limit
+		excess := []playSession{}
+
+		for _, s := range userSessions[limit:] {
+			if !notified[s.Session.ID] {
+				excess = append(excess, s)
+			}
+
+			notified[s.Session.ID] = true
+		}
+
+		if len(excess) == 0 {
+			continue
+		}
+
+		who := "**" + plexUser + "**"
+
+		if discordID != "" {
+			who += " (<@" + discordID + ">)"
+		}
+
+		lines := make([]string, len(userSessions))
+
+		for i, s := range userSessions {
+			lines[i] = describeSession(services, guildID, s)
+		}
+
+		summary := fmt.Sprintf("%s is playing %d streams, their limit is %s:\n%s", who, len(userSessions), describeLimit(limit), strings.Join(lines, "\n"))
+
+		switch settings.StreamLimitAction {
+		case streamLimitKill:
+			reason := fmt.Sprintf("You can only play %s at once from this server", describeLimit(limit))
+			failed := terminateSessions(link, guildID, commandList.discord.State.User.ID, reason, excess)
+
+			if len(failed) > 0 {
+				summary += fmt.Sprintf("\ncould not stop the newest %d stream(s), stopping streams needs plex pass", len(failed))
+			} else {
+				summary += fmt.Sprintf("\nstopped the newest %d stream(s)", len(excess))
+			}
+
+		case streamLimitDM:
+			if discordID == "" {
+				summary += "\nthey have no linked discord account to DM"
+				break
+			}
+
+			notice := fmt.Sprintf("You are playing %d streams from our Plex server but you can only play %s at once -- please stop %d of them",
+				len(userSessions), describeLimit(limit), len(userSessions)-limit)
+
+			if err := commandList.sendDM(discordID, notice); err != nil {
+				fmt.Printf("checkStreamLimits() - could not DM %s: %v\n", discordID, err)
+				summary += "\ncould not DM them"
+				break
+			}
+
+			// admins only hear about it when the DM didn't go through
+			continue
+		}
+
+		alertAdmins(commandList, services, guildID, summary)
+	}
+}
+
+// streamLimits shows or changes the stream limits of discord roles
+//
+// command: stream-limits | stream-limits set @role <limit> | stream-limits remove @role
+func streamLimits(commandList d, services *clients) func(m *discordgo.Message, args ...string) bool {
+	return func(m *discordgo.Message, args ...string) bool {
+		channelID := m.ChannelID
+		guildID := commandList.getGuildID(channelID)
+
+		if guildID == "" {
+			commandList.showError(channelID, "stream limits can only be managed from a server channel")
+			return false
+		}
+
+		if len(args) < 1 {
+			settings := services.getGuildSettings(guildID)
+
+			lines := make([]string, len(settings.StreamLimits))
+
+			for i, tier := range settings.StreamLimits {
+				lines[i] = fmt.Sprintf("<@&%s>: %s", tier.RoleID, describeLimit(tier.Limit))
+			}
+
+			sort.Strings(lines)
+
+			message := "Everyone: " + describeLimit(settings.StreamLimit)
+
+			if len(lines) > 0 {
+				message += "\n" + strings.Join(lines, "\n")
+			}
+
+			commandList.discord.ChannelMessageSend(channelID, "Stream limits:\n"+message)
+
+			return true
+		}
+
+		if (args[0] != "set" && args[0] != "remove") || len(args) < 2 {
+			commandList.showError(channelID, "usage: `stream-limits`, `stream-limits set @role <limit>` or `stream-limits remove @role`")
+			return false
+		}
+
+		roleID, ok := parseRoleMention(args[1])
+
+		if !ok {
+			commandList.showError(channelID, fmt.Sprintf("`%s` is not a role -- use `@role`", args[1]))
+			return false
+		}
+
+		tier := roleStreamLimit{RoleID: roleID}
+
+		if args[0] == "set" {
+			var err error
+
+			if len(args) < 3 {
+				commandList.showError(channelID, "a limit is required, like `2` or `0` for no limit")
+				return false
+			}
+
+			if tier.Limit, err = strconv.Atoi(args[2]); err != nil || tier.Limit < 0 {
+				commandList.showError(channelID, fmt.Sprintf("`%s` should be a number, 0 for no limit", args[2]))
+				return false
+			}
+		}
+
+		err := services.updateGuildSettings(guildID, func(settings *guildSettings) {
+			tiers := settings.StreamLimits[:0]
+
+			for _, existing := range settings.StreamLimits {
+				if existing.RoleID != roleID {
+					tiers = append(tiers, existing)
+				}
+			}
+
+			if args[0] == "set" {
+				tiers = append(tiers, tier)
+			}
+
+			settings.StreamLimits = tiers
+		})
+
+		if err != nil {
+			fmt.Printf("streamLimits() - could not save settings: %v\n", err)
+			commandList.showError(channelID, "`internal error - could not save settings`")
+			return false
+		}
+
+		if args[0] == "set" {
+			audit(guildID, m.Author.ID, "stream-limit", fmt.Sprintf("role=%s limit=%d", roleID, tier.Limit))
+			commandList.discord.ChannelMessageSend(channelID, fmt.Sprintf("members of <@&%s> can play %s at once", roleID, describeLimit(tier.Limit)))
+		} else {
+			audit(guildID, m.Author.ID, "stream-limit", fmt.Sprintf("role=%s removed", roleID))
+			commandList.discord.ChannelMessageSend(channelID, fmt.Sprintf("members of <@&%s> have the server's stream limit again", roleID))
+		}
+
+		return true
+	}
+}
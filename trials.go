@@ -0,0 +1,236 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	// trialCheckInterval is how often we look for trials to remind about or expire
+	trialCheckInterval = 5 * time.Minute
+	// trialReminder is how long before a trial expires we remind the user and admins
+	trialReminder = 48 * time.Hour
+)
+
+// trial is plex access given with invite --expires that is removed when it expires
+type trial struct {
+	GuildID         string    `toml:"guildID"`
+	ChannelID       string    `toml:"channelID"`
+	MemberID        string    `toml:"memberID"`
+	UsernameOrEmail string    `toml:"usernameOrEmail"`
+	ExpiresAt       time.Time `toml:"expiresAt"`
+	RemindAt        time.Time `toml:"remindAt"`
+	Reminded        bool      `toml:"reminded"`
+}
+
+// newTrial starts a trial that lasts length from now
+func newTrial(guildID, channelID, memberID, usernameOrEmail string, length time.Duration) trial {
+	t := trial{
+		GuildID:         guildID,
+		ChannelID:       channelID,
+		MemberID:        memberID,
+		UsernameOrEmail: usernameOrEmail,
+	}
+
+	t.setExpiry(time.Now().Add(length))
+
+	return t
+}
+
+// setExpiry moves the expiry and schedules a new reminder
+//
+// short trials are reminded halfway through instead of trialReminder before
+func (t *trial) setExpiry(expiresAt time.Time) {
+	reminder := trialReminder
+
+	if remaining := time.Until(expiresAt); remaining < 2*trialReminder {
+		reminder = remaining / 2
+	}
+
+	t.ExpiresAt = expiresAt
+	t.RemindAt = expiresAt.Add(-reminder)
+	t.Reminded = false
+}
+
+// formatUntil turns a time into something like "in 3d"
+func formatUntil(t time.Time) string {
+	remaining := time.Until(t)
+
+	switch {
+	case remaining <= 0:
+		return "now"
+	case remaining < time.Hour:
+		return fmt.Sprintf("in %dm", int(remaining.Minutes())+1)
+	case remaining < 24*time.Hour:
+		return fmt.Sprintf("in %dh", int(remaining.Hours()))
+	default:
+		return fmt.Sprintf("in %dd", int(remaining.Hours()/24))
+	}
+}
+
+// notifyTrialUser tells the member of a trial something by DM, or in the channel they were invited from
+func notifyTrialUser(commandList d, t trial, message string) {
+	if t.MemberID == "" {
+		return
+	}
+
+	if err := commandList.sendDM(t.MemberID, message); err != nil {
+		commandList.discord.ChannelMessageSend(t.ChannelID, "<@"+t.MemberID+"> "+message)
+	}
+}
+
+// removeTrial forgets the trial of usernameOrEmail in a guild
+func removeTrial(services *clients, guildID, usernameOrEmail string) error {
+	return services.data.update(func(data *dobbyData) {
+		trials := data.Trials[:0]
+
+		for _, t := range data.Trials {
+			if t.GuildID == guildID && strings.EqualFold(t.UsernameOrEmail, usernameOrEmail) {
+				continue
+			}
+
+			trials = append(trials, t)
+		}
+
+		data.Trials = trials
+	})
+}
+
+// watchTrials periodically reminds about trials that are about to end and removes expired ones
+func watchTrials(commandList d, services *clients) {
+	for {
+		time.Sleep(trialCheckInterval)
+
+		checkTrials(commandList, services)
+	}
+}
+
+func checkTrials(commandList d, services *clients) {
+	var trials []trial
+	now := time.Now()
+
+	services.data.view(func(data dobbyData) {
+		trials = append(trials, data.Trials...)
+	})
+
+	for _, t := range trials {
+		if now.After(t.ExpiresAt) {
+			expireTrial(commandList, services, t)
+			continue
+		}
+
+		if t.Reminded || now.Before(t.RemindAt) {
+			continue
+		}
+
+		notifyTrialUser(commandList, t, fmt.Sprintf("Your access to our Plex server expires %s -- ask an admin if you need more time", formatUntil(t.ExpiresAt)))
+
+		alertAdmins(commandList, services, t.GuildID, fmt.Sprintf("trial access of `%s` expires %s -- `extend %s 7d` to give them more time",
+			t.UsernameOrEmail, formatUntil(t.ExpiresAt), t.UsernameOrEmail))
+
+		err := services.data.update(func(data *dobbyData) {
+			for i := range data.Trials {
+				if data.Trials[i].GuildID == t.GuildID && strings.EqualFold(data.Trials[i].UsernameOrEmail, t.UsernameOrEmail) {
+					data.Trials[i].Reminded = true
+				}
+			}
+		})
+
+		if err != nil {
+			fmt.Printf("checkTrials() - could not save trials: %v\n", err)
+		}
+	}
+}
+
+// expireTrial removes the plex access of a trial that ran out
+func expireTrial(commandList d, services *clients, t trial) {
+	_, err := revokeAccess(services, t.GuildID, t.UsernameOrEmail)
+
+	if err != nil && !errors.Is(err, errNoAccess) {
+		// keep the trial around and try again on the next check
+		fmt.Printf("expireTrial() - could not revoke %s: %v\n", t.UsernameOrEmail, err)
+		return
+	}
+
+	if err := removeTrial(services, t.GuildID, t.UsernameOrEmail); err != nil {
+		fmt.Printf("expireTrial() - could not save trials: %v\n", err)
+	}
+
+	// already removed by hand
+	if err != nil {
+		return
+	}
+
+	audit(t.GuildID, commandList.discord.State.User.ID, "trial-expired", "user="+t.UsernameOrEmail)
+
+	notifyTrialUser(commandList, t, "Your trial access to our Plex server has ended")
+
+	alertAdmins(commandList, services, t.GuildID, fmt.Sprintf("trial access of `%s` expired and was removed", t.UsernameOrEmail))
+}
+
+// extend gives trial access more time
+//
+// command: extend <username|email|@member> <7d>
+func extend(commandList d, services *clients) func(m *discordgo.Message, args ...string) bool {
+	return func(m *discordgo.Message, args ...string) bool {
+		channelID := m.ChannelID
+		guildID := commandList.getGuildID(channelID)
+
+		if len(args) < 2 {
+			commandList.showError(channelID, "usage: `extend <username|email|@member> <duration>`, like `extend bob 7d`")
+			return false
+		}
+
+		target, _, err := resolvePlexUser(services, guildID, args[0])
+
+		if err != nil {
+			commandList.showError(channelID, err.Error())
+			return false
+		}
+
+		length, err := parseDuration(args[1])
+
+		if err != nil {
+			commandList.showError(channelID, err.Error())
+			return false
+		}
+
+		var extended trial
+		found := false
+
+		err = services.data.update(func(data *dobbyData) {
+			for i := range data.Trials {
+				if data.Trials[i].GuildID != guildID || !strings.EqualFold(data.Trials[i].UsernameOrEmail, target) {
+					continue
+				}
+
+				data.Trials[i].setExpiry(data.Trials[i].ExpiresAt.Add(length))
+				extended = data.Trials[i]
+				found = true
+			}
+		})
+
+		if err != nil {
+			fmt.Printf("extend() - could not save trials: %v\n", err)
+			commandList.showError(channelID, "`internal error - could not save trials`")
+			return false
+		}
+
+		if !found {
+			commandList.showError(channelID, fmt.Sprintf("`%s` does not have trial access", target))
+			return false
+		}
+
+		audit(guildID, m.Author.ID, "extend-trial", fmt.Sprintf("user=%s by=%s expires=%s", target, args[1], extended.ExpiresAt.Format(time.RFC3339)))
+
+		notifyTrialUser(commandList, extended, fmt.Sprintf("Your access to our Plex server was extended, it now expires %s", formatUntil(extended.ExpiresAt)))
+
+		commandList.discord.ChannelMessageSend(channelID, fmt.Sprintf("trial access of `%s` now expires %s", target, formatUntil(extended.ExpiresAt)))
+
+		return true
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/jrudio/go-plex-client"
+)
+
+// plexTVDevices is the response of plex.tv/devices.xml
+type plexTVDevices struct {
+	Device []struct {
+		ID               int    `xml:"id,attr"`
+		Name             string `xml:"name,attr"`
+		ClientIdentifier string `xml:"clientIdentifier,attr"`
+		Token            string `xml:"token,attr"`
+	} `xml:"Device"`
+}
+
+// revokePlexToken removes dobby from the authorized devices of the plex account
+// which invalidates our token
+//
+// every copy of dobby shares a client identifier so the device is found by its token
+func revokePlexToken(plexClient *plex.Plex) error {
+	resp, err := plexTVRequest(plexClient, "GET", plexTVURL+"/devices.xml", nil)
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(resp.Status)
+	}
+
+	var devices plexTVDevices
+
+	if err := xml.NewDecoder(resp.Body).Decode(&devices); err != nil {
+		return err
+	}
+
+	for _, device := range devices.Device {
+		if device.Token == "" || device.Token != plexClient.Token {
+			continue
+		}
+
+		resp, err := plexTVRequest(plexClient, "DELETE", plexTVURL+"/devices/"+strconv.Itoa(device.ID)+".xml", nil)
+
+		if err != nil {
+			return err
+		}
+
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return errors.New(resp.Status)
+		}
+
+		return nil
+	}
+
+	return errors.New("dobby is not in the authorized devices of this plex account")
+}
+
+// unlink disconnects dobby from the plex account of the guild
+func unlink(commandList d, services *clients) func(m *discordgo.Message, args ...string) bool {
+	return func(m *discordgo.Message, args ...string) bool {
+		channelID := m.ChannelID
+		link := services.getPlexLink(commandList.getGuildID(channelID))
+
+		if link.client().Token == "" {
+			commandList.showError(channelID, "dobby is not linked to a plex account")
+			return false
+		}
+
+		message := "Dobby is no longer linked to your Plex account"
+
+		if err := revokePlexToken(link.client()); err != nil {
+			fmt.Printf("unlink() - could not revoke plex token: %v\n", err)
+			message += "\ncould not revoke dobby's token on plex.tv, you can remove Dobby by hand at https://app.plex.tv/desktop#!/settings/devices/all"
+		}
+
+		link.setPlexToken("")
+		link.setPlexHost("")
+		link.setAuthorized(false)
+
+		err := updateCredentials(secretsFilepath,
+			credentialUpdate{table: link.credentialsTable(), key: "token", remove: true},
+			credentialUpdate{table: link.credentialsTable(), key: "host", remove: true},
+		)
+
+		if err != nil {
+			fmt.Printf("unlink() - updateCredentials failed: %v\n", err)
+			commandList.showError(channelID, "`internal error - could not remove plex authorization token from secrets.toml`")
+			return false
+		}
+
+		services.updatePlexCredentials(link, func(credentials *plexCredentials) {
+			credentials.Token = ""
+			credentials.Host = ""
+		})
+
+		commandList.discord.ChannelMessageSend(channelID, message)
+
+		return true
+	}
+}
+
+// linkPlex links dobby to a plex account or shows what dobby is linked to
+//
+// command: link [status]
+func linkPlex(commandList d, services *clients) func(m *discordgo.Message, args ...string) bool {
+	return func(m *discordgo.Message, args ...string) bool {
+		if len(args) < 1 {
+			return displayPlexPIN(commandList, services)(m, args...)
+		}
+
+		if args[0] != "status" {
+			commandList.showError(m.ChannelID, fmt.Sprintf("unknown subcommand `%s` for command `link`", args[0]))
+			return false
+		}
+
+		commandList.discord.ChannelMessageSend(m.ChannelID, linkStatus(services.getPlexLink(commandList.getGuildID(m.ChannelID))))
+
+		return true
+	}
+}
+
+// linkStatus describes the plex account and server a link points to
+func linkStatus(link *plexLink) string {
+	if link.client().Token == "" {
+		return "Dobby is not linked to a Plex account -- run `link` to link one"
+	}
+
+	status := "Dobby is linked"
+
+	if link.guildID != "" {
+		status += " for this server only"
+	}
+
+	account, err := link.client().MyAccount()
+
+	if err != nil {
+		fmt.Printf("linkStatus() - could not fetch plex account: %v\n", err)
+		status += "\nPlex account: `unknown`"
+	} else {
+		status += fmt.Sprintf("\nPlex account: `%s` (%s)", account.Username, account.Email)
+	}
+
+	serverName := "none selected"
+
+	if serverInfo, err := link.client().GetServersInfo(); err == nil {
+		for _, server := range serverInfo.Server {
+			if server.Scheme+"://"+server.Address+":"+server.Port == link.client().URL {
+				serverName = server.Name
+				break
+			}
+		}
+	}
+
+	status += fmt.Sprintf("\nPlex server: `%s`", serverName)
+
+	if link.authorized() {
+		status += "\nAuthorized: yes"
+	} else {
+		status += "\nAuthorized: no -- run `link` to get a new Plex PIN"
+	}
+
+	return status
+}
@@ -9,6 +9,8 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/BurntSushi/toml"
@@ -67,6 +69,7 @@ func getCredentials() (serviceCredentials, error) {
 
 	flag.StringVar(&credentials.DiscordToken, "discord-token", "", "token used for bot authentication")
 	flag.BoolVar(&isVerbose, "verbose", false, "output more inforation")
+	flag.StringVar(&keyFilepath, "key-file", "", "file holding the passphrase used to encrypt tokens in secrets.toml")
 	versionFlag = flag.Bool("version", false, "get program version")
 
 	flag.Parse()
@@ -86,18 +89,12 @@ func getCredentials() (serviceCredentials, error) {
 
 // getCredentialsTOML grabs apikeys and auth tokens via .toml file
 func getCredentialsTOML(path string) (serviceCredentials, error) {
-	credentials := serviceCredentials{}
-
-	fileBytes, err := ioutil.ReadFile(path)
+	credentials, err := readCredentialsTOML(path)
 
 	if err != nil {
 		return credentials, err
 	}
 
-	if err := toml.Unmarshal(fileBytes, &credentials); err != nil {
-		return credentials, err
-	}
-
 	if credentials.DiscordToken == "" {
 		return credentials, errors.New(errDiscordTokenRequired)
 	}
@@ -105,14 +102,23 @@ func getCredentialsTOML(path string) (serviceCredentials, error) {
 	return credentials, nil
 }
 
-func saveCredentials(credentials serviceCredentials, filename string) error {
-	f, err := os.Create(filename)
+// readCredentialsTOML is getCredentialsTOML without requiring a discord token
+func readCredentialsTOML(path string) (serviceCredentials, error) {
+	credentials := serviceCredentials{}
+
+	fileBytes, err := ioutil.ReadFile(path)
 
 	if err != nil {
-		return err
+		return credentials, err
+	}
+
+	if err := toml.Unmarshal(fileBytes, &credentials); err != nil {
+		return credentials, err
 	}
 
-	return toml.NewEncoder(f).Encode(credentials)
+	err = decryptCredentials(&credentials)
+
+	return credentials, err
 }
 
 // func initializeClients(credentials serviceCredentials) (*clients, error) {
@@ -125,6 +131,206 @@ func saveCredentials(credentials serviceCredentials, filename string) error {
 // 	return services, err
 // }
 
+// splitArgs splits a message on spaces while keeping "quoted text" together
+func splitArgs(content string) []string {
+	args := []string{}
+	current := ""
+	inQuotes := false
+	hasArg := false
+
+	for _, r := range content {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+			hasArg = true
+		case r == ' ' && !inQuotes:
+			if hasArg {
+				args = append(args, current)
+			}
+
+			current = ""
+			hasArg = false
+		default:
+			current += string(r)
+			hasArg = true
+		}
+	}
+
+	if hasArg {
+		args = append(args, current)
+	}
+
+	return args
+}
+
+// optionList declares the options a command understands, true for options that take a value
+type optionList map[string]bool
+
+// with returns the options of both lists
+func (o optionList) with(more optionList) optionList {
+	merged := optionList{}
+
+	for name, takesValue := range o {
+		merged[name] = takesValue
+	}
+
+	for name, takesValue := range more {
+		merged[name] = takesValue
+	}
+
+	return merged
+}
+
+// parseOptions separates the options in known from the rest of args
+//
+// options that take a value are written --key=value or --key value, flags are written --key,
+// which sets them to "true", or --key=false. unknown options are an error
+func parseOptions(args []string, known optionList) ([]string, map[string]string, error) {
+	positional := []string{}
+	options := map[string]string{}
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+
+		if !strings.HasPrefix(arg, "--") {
+			positional = append(positional, arg)
+			continue
+		}
+
+		name := strings.ToLower(strings.TrimPrefix(arg, "--"))
+		value := ""
+		hasValue := false
+
+		if j := strings.Index(name, "="); j > -1 {
+			name, value, hasValue = name[:j], arg[len("--")+j+1:], true
+		}
+
+		takesValue, ok := known[name]
+
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown option `--%s`", name)
+		}
+
+		if !hasValue && !takesValue {
+			value = "true"
+		} else if !hasValue {
+			if i+1 >= len(args) || strings.HasPrefix(args[i+1], "--") {
+				return nil, nil, fmt.Errorf("`--%s` needs a value", name)
+			}
+
+			value = args[i+1]
+			i++
+		}
+
+		options[name] = value
+	}
+
+	return positional, options, nil
+}
+
+// maxMessageLength is the most characters discord allows in a message
+const maxMessageLength = 2000
+
+// splitMessage cuts text into parts of at most limit characters, between lines when it can
+func splitMessage(text string, limit int) []string {
+	parts := []string{}
+	current := ""
+
+	for _, line := range strings.Split(text, "\n") {
+		// a line that doesn't fit in a message of its own is cut up
+		for len([]rune(line)) > limit {
+			if current != "" {
+				parts = append(parts, current)
+				current = ""
+			}
+
+			runes := []rune(line)
+			parts = append(parts, string(runes[:limit]))
+			line = string(runes[limit:])
+		}
+
+		switch {
+		case current == "":
+			current = line
+		case len([]rune(current))+1+len([]rune(line)) <= limit:
+			current += "\n" + line
+		default:
+			parts = append(parts, current)
+			current = line
+		}
+	}
+
+	if strings.TrimSpace(current) != "" {
+		parts = append(parts, current)
+	}
+
+	return parts
+}
+
+// parseMention returns the user id of a discord mention like <@123> or <@!123>
+func parseMention(arg string) (string, bool) {
+	if !strings.HasPrefix(arg, "<@") || strings.HasPrefix(arg, "<@&") || !strings.HasSuffix(arg, ">") {
+		return "", false
+	}
+
+	userID := strings.TrimPrefix(strings.TrimSuffix(arg, ">"), "<@")
+	userID = strings.TrimPrefix(userID, "!")
+
+	return userID, userID != ""
+}
+
+// parseRoleMention returns the role id of a discord role mention like <@&123>
+func parseRoleMention(arg string) (string, bool) {
+	if !strings.HasPrefix(arg, "<@&") || !strings.HasSuffix(arg, ">") {
+		return "", false
+	}
+
+	roleID := strings.TrimPrefix(strings.TrimSuffix(arg, ">"), "<@&")
+
+	return roleID, roleID != ""
+}
+
+// parseChannelMention returns the channel id of a discord channel mention like <#123>
+func parseChannelMention(arg string) (string, bool) {
+	if !strings.HasPrefix(arg, "<#") || !strings.HasSuffix(arg, ">") {
+		return "", false
+	}
+
+	channelID := strings.TrimPrefix(strings.TrimSuffix(arg, ">"), "<#")
+
+	return channelID, channelID != ""
+}
+
+// parseDuration is time.ParseDuration that also understands days and weeks like 14d or 2w
+func parseDuration(s string) (time.Duration, error) {
+	units := map[string]time.Duration{
+		"d": 24 * time.Hour,
+		"w": 7 * 24 * time.Hour,
+	}
+
+	for suffix, unit := range units {
+		if !strings.HasSuffix(s, suffix) {
+			continue
+		}
+
+		count, err := strconv.Atoi(strings.TrimSuffix(s, suffix))
+
+		if err != nil || count < 0 {
+			return 0, fmt.Errorf("`%s` is not a duration -- use something like 12h, 14d or 2w", s)
+		}
+
+		return time.Duration(count) * unit, nil
+	}
+
+	duration, err := time.ParseDuration(s)
+
+	if err != nil || duration < 0 {
+		return 0, fmt.Errorf("`%s` is not a duration -- use something like 12h, 14d or 2w", s)
+	}
+
+	return duration, nil
+}
+
 func logPrint(chanID, message string) {
 	fmt.Printf("%s - channel id: %s - %s\n", time.Now().String(), chanID, message)
 }
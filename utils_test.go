@@ -0,0 +1,147 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseOptions(t *testing.T) {
+	known := optionList{"notify": false, "reason": true, "allow-sync": false, "libraries": true}
+
+	tests := []struct {
+		name           string
+		args           []string
+		wantPositional []string
+		wantOptions    map[string]string
+		wantErr        bool
+	}{
+		{
+			name:           "flags do not take the next argument",
+			args:           []string{"--notify", "bob"},
+			wantPositional: []string{"bob"},
+			wantOptions:    map[string]string{"notify": "true"},
+		},
+		{
+			name:           "options take the next argument",
+			args:           []string{"bob", "--reason", "no longer a member"},
+			wantPositional: []string{"bob"},
+			wantOptions:    map[string]string{"reason": "no longer a member"},
+		},
+		{
+			name:           "key=value",
+			args:           []string{"--libraries=Movies,TV", "--allow-sync=false", "bob"},
+			wantPositional: []string{"bob"},
+			wantOptions:    map[string]string{"libraries": "Movies,TV", "allow-sync": "false"},
+		},
+		{
+			name:           "names ignore case but values keep it",
+			args:           []string{"--Reason=Moved Out"},
+			wantPositional: []string{},
+			wantOptions:    map[string]string{"reason": "Moved Out"},
+		},
+		{
+			name:    "unknown options are an error",
+			args:    []string{"bob", "--notfiy"},
+			wantErr: true,
+		},
+		{
+			name:    "options need a value",
+			args:    []string{"bob", "--reason"},
+			wantErr: true,
+		},
+		{
+			name:    "another option is not a value",
+			args:    []string{"--reason", "--notify"},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			positional, options, err := parseOptions(test.args, known)
+
+			if (err != nil) != test.wantErr {
+				t.Fatalf("parseOptions() error = %v, wantErr %v", err, test.wantErr)
+			}
+
+			if test.wantErr {
+				return
+			}
+
+			if !reflect.DeepEqual(positional, test.wantPositional) {
+				t.Errorf("positional = %q, want %q", positional, test.wantPositional)
+			}
+
+			if !reflect.DeepEqual(options, test.wantOptions) {
+				t.Errorf("options = %q, want %q", options, test.wantOptions)
+			}
+		})
+	}
+}
+
+func TestSplitMessage(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		limit int
+		want  []string
+	}{
+		{name: "short", text: "a\nb", limit: 10, want: []string{"a\nb"}},
+		{name: "between lines", text: "aaaa\nbbbb\ncc", limit: 9, want: []string{"aaaa\nbbbb", "cc"}},
+		{name: "long lines are cut", text: "ab\ncdefghij", limit: 4, want: []string{"ab", "cdef", "ghij"}},
+		{name: "counts characters not bytes", text: "ééé\nééé", limit: 7, want: []string{"ééé\nééé"}},
+		{name: "empty", text: "", limit: 10, want: []string{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := splitMessage(test.text, test.limit)
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("splitMessage() = %q, want %q", got, test.want)
+			}
+
+			for _, part := range got {
+				if len([]rune(part)) > test.limit {
+					t.Errorf("part %q is over the limit", part)
+				}
+			}
+		})
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{name: "hours", in: "12h", want: 12 * time.Hour},
+		{name: "days", in: "14d", want: 14 * 24 * time.Hour},
+		{name: "weeks", in: "2w", want: 14 * 24 * time.Hour},
+		{name: "go durations", in: "1h30m", want: 90 * time.Minute},
+		{name: "zero", in: "0d", want: 0},
+		{name: "negative days", in: "-1d", wantErr: true},
+		{name: "negative hours", in: "-2h", wantErr: true},
+		{name: "days are whole", in: "1.5d", wantErr: true},
+		{name: "no unit", in: "14", wantErr: true},
+		{name: "garbage", in: "soon", wantErr: true},
+		{name: "empty", in: "", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseDuration(test.in)
+
+			if (err != nil) != test.wantErr {
+				t.Fatalf("parseDuration(%q) error = %v, wantErr %v", test.in, err, test.wantErr)
+			}
+
+			if !test.wantErr && got != test.want {
+				t.Errorf("parseDuration(%q) = %v, want %v", test.in, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// plexUsernamePattern is what plex.tv accepts as a username
+var plexUsernamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// plexAPIError is an error response from plex.tv
+type plexAPIError struct {
+	status     string
+	statusCode int
+	// message is what plex.tv said went wrong, if anything
+	message string
+}
+
+func (e *plexAPIError) Error() string {
+	if e.message != "" {
+		return e.status + ": " + e.message
+	}
+
+	return e.status
+}
+
+// newPlexAPIError reads the error plex.tv sent back
+//
+// the v2 api answers with {"errors": [{"code": 1001, "message": "..."}]}
+func newPlexAPIError(resp *http.Response) error {
+	apiError := &plexAPIError{status: resp.Status, statusCode: resp.StatusCode}
+
+	body, err := ioutil.ReadAll(resp.Body)
+
+	if err != nil {
+		return apiError
+	}
+
+	var result struct {
+		Errors []struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+
+	if json.Unmarshal(body, &result) == nil && len(result.Errors) > 0 {
+		apiError.message = result.Errors[0].Message
+	}
+
+	return apiError
+}
+
+// validateInviteeSyntax checks that an invite goes to something that looks like an email or a plex username
+func validateInviteeSyntax(usernameOrEmail string) error {
+	if !strings.Contains(usernameOrEmail, "@") {
+		if !plexUsernamePattern.MatchString(usernameOrEmail) {
+			return fmt.Errorf("`%s` is not a valid plex username -- usernames only have letters, numbers, `.`, `_` and `-`", usernameOrEmail)
+		}
+
+		return nil
+	}
+
+	address, err := mail.ParseAddress(usernameOrEmail)
+
+	if err != nil || address.Address != usernameOrEmail {
+		return fmt.Errorf("`%s` is not a valid email address", usernameOrEmail)
+	}
+
+	domain := usernameOrEmail[strings.LastIndex(usernameOrEmail, "@")+1:]
+
+	if !strings.Contains(domain, ".") || strings.HasPrefix(domain, ".") || strings.HasSuffix(domain, ".") {
+		return fmt.Errorf("`%s` is not a valid email address -- check the part after the @", usernameOrEmail)
+	}
+
+	return nil
+}
+
+// validateInvitee checks an invite can go through before we send it:
+// the username or email is valid, the plex user exists and they don't already have access.
+// shared are the users we share with, fetched from plex.tv when nil
+func validateInvitee(link *plexLink, machineID string, shared []sharedServer, usernameOrEmail string) error {
+	if err := validateInviteeSyntax(usernameOrEmail); err != nil {
+		return err
+	}
+
+	exists, err := link.client().CheckUsernameOrEmail(usernameOrEmail)
+
+	switch {
+	case err != nil:
+		// plex.tv will tell us again when we send the invite
+		fmt.Printf("validateInvitee() - could not check %s: %v\n", usernameOrEmail, err)
+	case !exists && strings.Contains(usernameOrEmail, "@"):
+		return fmt.Errorf("plex does not accept `%s` as an email address", usernameOrEmail)
+	case !exists:
+		return fmt.Errorf("there is no plex user named `%s` -- check the spelling or invite their email instead", usernameOrEmail)
+	}
+
+	if shared == nil {
+		if shared, err = getSharedServers(link.client(), machineID); err != nil {
+			fmt.Printf("validateInvitee() - could not fetch shared users: %v\n", err)
+			return nil
+		}
+	}
+
+	user, ok := findSharedServer(shared, usernameOrEmail)
+
+	switch {
+	case !ok:
+		return nil
+	case user.isPending():
+		return fmt.Errorf("`%s` already has a pending invite from %s -- they need to accept it, or use `invites cancel %s` to start over",
+			user.name(), formatAge(time.Unix(user.InvitedAt, 0)), usernameOrEmail)
+	default:
+		return fmt.Errorf("`%s` already has access to our plex server", user.name())
+	}
+}
+
+// describeInviteError turns an error from sending an invite into something the user can act on
+func describeInviteError(usernameOrEmail string, err error) error {
+	var apiError *plexAPIError
+
+	if !errors.As(err, &apiError) {
+		return fmt.Errorf("invite could not be sent to %s -- could not reach plex.tv, try again later", usernameOrEmail)
+	}
+
+	message := ""
+
+	switch {
+	case apiError.statusCode == http.StatusUnauthorized:
+		message = "dobby's plex token is no longer valid -- an admin needs to run `link` again"
+	case apiError.statusCode == http.StatusForbidden:
+		message = "the linked plex account is not allowed to share this server"
+	case apiError.statusCode == http.StatusNotFound:
+		message = fmt.Sprintf("plex.tv could not find `%s` or our server", usernameOrEmail)
+	case apiError.statusCode == http.StatusConflict || apiError.statusCode == http.StatusUnprocessableEntity:
+		message = fmt.Sprintf("`%s` already has access or a pending invite", usernameOrEmail)
+	case apiError.statusCode == http.StatusTooManyRequests:
+		message = "plex.tv is rate limiting us, try again in a minute"
+	case apiError.statusCode >= 500:
+		message = "plex.tv is having trouble, try again later"
+	default:
+		message = "plex.tv said " + apiError.status
+	}
+
+	if apiError.message != "" {
+		message += fmt.Sprintf(" (%s)", apiError.message)
+	}
+
+	return fmt.Errorf("invite could not be sent to %s: %s", usernameOrEmail, message)
+}